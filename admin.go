@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// ErrUnauthorized is returned by admin endpoints without a valid token.
+const ErrUnauthorized = "UNAUTHORIZED"
+
+// adminToken guards the endpoints that change the helper's state (/update,
+// /update/pause, /update/resume, /cache). Set from TATATEXT_ADMIN_TOKEN;
+// empty disables them.
+var adminToken string
+
+// adminOnly wraps h so it only runs for requests carrying the admin token in
+// an X-Admin-Token header. A custom header also forces a CORS preflight, so
+// a cross-site form POST from another page can't reach h.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			writeJSONError(w, http.StatusForbidden, ErrUnauthorized,
+				"admin endpoints are disabled; set TATATEXT_ADMIN_TOKEN to enable them")
+			return
+		}
+		got := r.Header.Get("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(adminToken)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, ErrUnauthorized, "missing or wrong X-Admin-Token")
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "regexp"
+
+// ytdlpAssetPatterns lists, per GOOS or GOOS/GOARCH, the release asset names
+// we can run, in order of preference. An arch-specific list is used when
+// there is one. yt-dlp has renamed its builds before (yt-dlp_macos,
+// yt-dlp_macos_legacy, ...), so later entries catch plausible variants
+// rather than failing the update outright.
+var ytdlpAssetPatterns = map[string][]*regexp.Regexp{
+	"windows": {
+		regexp.MustCompile(`^yt-dlp\.exe$`),
+		regexp.MustCompile(`^yt-dlp_win(64|_x64)?\.exe$`),
+	},
+	"linux": {
+		regexp.MustCompile(`^yt-dlp_linux$`),
+		regexp.MustCompile(`^yt-dlp_linux_(x86_64|x64|amd64)$`),
+	},
+	// Older releases have no aarch64 build; the zipapp needs python3 but beats
+	// downloading an x86_64 binary that can't execute
+	"linux/arm64": {
+		regexp.MustCompile(`^yt-dlp_linux_aarch64$`),
+		regexp.MustCompile(`^yt-dlp_linux_arm64$`),
+		regexp.MustCompile(`^yt-dlp$`),
+	},
+	// yt-dlp_macos is a universal binary; an arm64-only build is smaller, so
+	// it wins if one is ever published
+	"darwin/arm64": {
+		regexp.MustCompile(`^yt-dlp_macos_(arm64|aarch64)$`),
+		regexp.MustCompile(`^yt-dlp_macos$`),
+		regexp.MustCompile(`^yt-dlp_macos_universal$`),
+	},
+	"darwin": {
+		regexp.MustCompile(`^yt-dlp_macos$`),
+		regexp.MustCompile(`^yt-dlp_macos_universal$`),
+		regexp.MustCompile(`^yt-dlp_macos_legacy$`),
+	},
+}
+
+// matchYtDlpAsset returns the index of the preferred asset among names for
+// goos/goarch, and the pattern that picked it, or -1 if none match.
+func matchYtDlpAsset(names []string, goos, goarch string) (index int, pattern string) {
+	patterns, ok := ytdlpAssetPatterns[goos+"/"+goarch]
+	if !ok {
+		patterns = ytdlpAssetPatterns[goos]
+	}
+	for _, re := range patterns {
+		for i, n := range names {
+			if re.MatchString(n) {
+				return i, re.String()
+			}
+		}
+	}
+	return -1, ""
+}
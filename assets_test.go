@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestMatchYtDlpAsset(t *testing.T) {
+	// Asset lists of real releases, and plausible future renames
+	current := []string{
+		"SHA2-256SUMS", "SHA2-512SUMS", "yt-dlp", "yt-dlp.exe", "yt-dlp.tar.gz",
+		"yt-dlp_linux", "yt-dlp_linux.zip", "yt-dlp_linux_aarch64", "yt-dlp_linux_armv7l",
+		"yt-dlp_macos", "yt-dlp_macos.zip", "yt-dlp_macos_legacy", "yt-dlp_min.exe",
+		"yt-dlp_win.zip", "yt-dlp_x86.exe",
+	}
+	old := []string{"SHA2-256SUMS", "yt-dlp", "yt-dlp.exe", "yt-dlp_linux", "yt-dlp_macos", "yt-dlp_macos_legacy"}
+	renamed := []string{
+		"SHA2-256SUMS", "yt-dlp_win64.exe", "yt-dlp_linux_x86_64", "yt-dlp_linux_arm64",
+		"yt-dlp_macos_universal", "yt-dlp_macos_arm64",
+	}
+	legacyMacOnly := []string{"yt-dlp_macos_legacy", "yt-dlp_linux"}
+	macArm64 := append([]string{"yt-dlp_macos_arm64"}, current...)
+
+	tests := []struct {
+		name   string
+		names  []string
+		goos   string
+		goarch string
+		want   string
+	}{
+		{"current windows", current, "windows", "amd64", "yt-dlp.exe"},
+		{"current linux", current, "linux", "amd64", "yt-dlp_linux"},
+		{"current linux arm64 prefers aarch64", current, "linux", "arm64", "yt-dlp_linux_aarch64"},
+		{"current darwin", current, "darwin", "amd64", "yt-dlp_macos"},
+		{"current darwin arm64", current, "darwin", "arm64", "yt-dlp_macos"},
+		{"darwin arm64 prefers an arm64 build over universal", macArm64, "darwin", "arm64", "yt-dlp_macos_arm64"},
+		{"darwin amd64 ignores an arm64 build", macArm64, "darwin", "amd64", "yt-dlp_macos"},
+		{"old linux arm64 falls back to the zipapp", old, "linux", "arm64", "yt-dlp"},
+		{"old windows", old, "windows", "amd64", "yt-dlp.exe"},
+		{"renamed windows", renamed, "windows", "amd64", "yt-dlp_win64.exe"},
+		{"renamed linux", renamed, "linux", "amd64", "yt-dlp_linux_x86_64"},
+		{"renamed linux arm64", renamed, "linux", "arm64", "yt-dlp_linux_arm64"},
+		{"renamed darwin arm64 prefers arm64 over universal", renamed, "darwin", "arm64", "yt-dlp_macos_arm64"},
+		{"renamed darwin", renamed, "darwin", "amd64", "yt-dlp_macos_universal"},
+		{"legacy mac build only", legacyMacOnly, "darwin", "amd64", "yt-dlp_macos_legacy"},
+		{"no windows asset", legacyMacOnly, "windows", "amd64", ""},
+		{"unknown OS", current, "plan9", "amd64", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, pattern := matchYtDlpAsset(tt.names, tt.goos, tt.goarch)
+			got := ""
+			if i >= 0 {
+				got = tt.names[i]
+			}
+			if got != tt.want {
+				t.Errorf("matched %q (pattern %q), want %q", got, pattern, tt.want)
+			}
+		})
+	}
+}
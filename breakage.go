@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for breakage detection, overridable with TATATEXT_BREAKAGE_THRESHOLD
+// (0 disables) and TATATEXT_BREAKAGE_WINDOW.
+const (
+	DEFAULT_BREAKAGE_THRESHOLD = 3
+	DEFAULT_BREAKAGE_WINDOW    = 10 * time.Minute
+	BREAKAGE_COOLDOWN          = time.Hour
+)
+
+// breakageSignatures are stderr fragments meaning yt-dlp's extractor itself
+// failed, as opposed to a problem with one video. Several of these in a short
+// time usually mean YouTube changed and yt-dlp needs an update.
+var breakageSignatures = []string{
+	"unable to extract",
+	"signature extraction failed",
+	"nsig extraction failed",
+	"please report this issue on",
+}
+
+// breakageDetector counts extractor failures in a sliding window and starts
+// an update check when they reach threshold.
+type breakageDetector struct {
+	mu          sync.Mutex
+	threshold   int
+	window      time.Duration
+	failures    []time.Time
+	lastTrigger time.Time
+}
+
+var breakage = &breakageDetector{threshold: DEFAULT_BREAKAGE_THRESHOLD, window: DEFAULT_BREAKAGE_WINDOW}
+
+func loadBreakageConfig() {
+	if v := os.Getenv("TATATEXT_BREAKAGE_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			breakage.threshold = n
+		} else {
+			log.Printf("ignoring invalid TATATEXT_BREAKAGE_THRESHOLD %q", v)
+		}
+	}
+	if v := os.Getenv("TATATEXT_BREAKAGE_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			breakage.window = d
+		} else {
+			log.Printf("ignoring invalid TATATEXT_BREAKAGE_WINDOW %q", v)
+		}
+	}
+}
+
+// record notes a failed yt-dlp run. Only extractor failures count.
+func (d *breakageDetector) record(stderr string, now time.Time) {
+	s := strings.ToLower(stderr)
+	matched := false
+	for _, sig := range breakageSignatures {
+		if strings.Contains(s, sig) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.threshold == 0 {
+		return
+	}
+	recent := d.failures[:0]
+	for _, t := range d.failures {
+		if now.Sub(t) < d.window {
+			recent = append(recent, t)
+		}
+	}
+	d.failures = append(recent, now)
+	if len(d.failures) < d.threshold || now.Sub(d.lastTrigger) < BREAKAGE_COOLDOWN {
+		return
+	}
+	d.lastTrigger = now
+	d.failures = nil
+	go respondToBreakage(d.threshold, d.window)
+}
+
+// respondToBreakage checks for a yt-dlp update right away, installing it
+// unless the update mode says otherwise, and tells the user what happened.
+func respondToBreakage(n int, window time.Duration) {
+	log.Printf("%d extraction failures within %s, checking for a yt-dlp update", n, window)
+	updateMu.Lock()
+	paused := updatePaused
+	updateMu.Unlock()
+	if updateMode == UPDATE_MANUAL || paused {
+		showNotification("tatatext Helper", "Downloads are failing, yt-dlp may need an update. Use /update to install the latest version.")
+		return
+	}
+	before := ytdlp.Load().version
+	if err := checkAndUpdate(updateMode == UPDATE_AUTO); err != nil {
+		showNotification("tatatext Helper", "Downloads are failing and checking for a yt-dlp update failed: "+err.Error())
+		return
+	}
+
+	updateMu.Lock()
+	available := availableVersion
+	updateMu.Unlock()
+	switch after := ytdlp.Load().version; {
+	case after != before:
+		showNotification("tatatext Helper", fmt.Sprintf("Downloads were failing, so yt-dlp was updated to %s.", after))
+	case available != "":
+		showNotification("tatatext Helper", fmt.Sprintf("Downloads are failing. yt-dlp %s is available, install it with /update.", available))
+	default:
+		showNotification("tatatext Helper", "Downloads are failing but yt-dlp is already the latest version; a fix may not be released yet.")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsPolicy answers CORS for every endpoint. A request whose Origin is on
+// the allowlist gets it echoed back; anything else gets the primary
+// tatatext.com origin, which the browser then rejects for foreign pages.
+type corsPolicy struct {
+	origins map[string]bool
+}
+
+// newCORSPolicy builds the allowlist from a comma-separated list of origins.
+// The primary origin is always allowed.
+func newCORSPolicy(list string) *corsPolicy {
+	c := &corsPolicy{origins: map[string]bool{primaryOrigin: true}}
+	for _, o := range strings.Split(list, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			c.origins[o] = true
+		}
+	}
+	return c
+}
+
+// middleware sets the CORS headers and answers preflight requests itself,
+// so handlers only deal with real requests.
+func (c *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		if c.origins[origin] {
+			h.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			h.Set("Access-Control-Allow-Origin", primaryOrigin)
+		}
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Expose-Headers", EXPOSED_HEADERS)
+		h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Content-Type, Range, X-Admin-Token")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
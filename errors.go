@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Error codes returned to the web app so it can show a specific message
+// instead of a raw yt-dlp error.
+const (
+	ErrPrivate            = "PRIVATE"
+	ErrGeoBlocked         = "GEO_BLOCKED"
+	ErrAgeRestricted      = "AGE_RESTRICTED"
+	ErrBotCheck           = "BOT_CHECK"
+	ErrUnavailable        = "UNAVAILABLE"
+	ErrUnsupportedURL     = "UNSUPPORTED_URL"
+	ErrRateLimited        = "RATE_LIMITED"
+	ErrExtractionFailed   = "EXTRACTION_FAILED"
+	ErrUnsupportedExt     = "UNSUPPORTED_EXT"
+	ErrMembersOnly        = "MEMBERS_ONLY"
+	ErrNotLive            = "NOT_LIVE"
+	ErrTooManyProcesses   = "TOO_MANY_PROCESSES"
+	ErrFormatUnavailable  = "FORMAT_UNAVAILABLE"
+	ErrTooManyExtractions = "TOO_MANY_EXTRACTIONS"
+	ErrEncoderUnavailable = "ENCODER_UNAVAILABLE"
+	ErrFFmpegMissing      = "FFMPEG_MISSING"
+	ErrNoSubtitles        = "NO_SUBTITLES"
+)
+
+// ytdlpErrorSignatures maps substrings of yt-dlp's stderr to error codes.
+// Order matters: the first match wins, so specific messages come before
+// generic ones like "Video unavailable".
+var ytdlpErrorSignatures = []struct {
+	substr string
+	code   string
+}{
+	{"ffmpeg not found", ErrFFmpegMissing},
+	{"ffmpeg is not installed", ErrFFmpegMissing},
+	{"members-only", ErrMembersOnly},
+	{"join this channel", ErrMembersOnly},
+	{"available to this channel's members", ErrMembersOnly},
+	{"private video", ErrPrivate},
+	{"not made this video available in your country", ErrGeoBlocked},
+	{"not available in your country", ErrGeoBlocked},
+	{"geo restriction", ErrGeoBlocked},
+	{"confirm your age", ErrAgeRestricted},
+	{"age-restricted", ErrAgeRestricted},
+	{"confirm you're not a bot", ErrBotCheck},
+	{"confirm you’re not a bot", ErrBotCheck},
+	{"http error 429", ErrRateLimited},
+	{"requested format is not available", ErrFormatUnavailable},
+	{"unsupported url", ErrUnsupportedURL},
+	{"is not a valid url", ErrUnsupportedURL},
+	{"video unavailable", ErrUnavailable},
+	{"this video is unavailable", ErrUnavailable},
+	{"has been removed", ErrUnavailable},
+}
+
+// classifyYtDlpError returns the error code for yt-dlp's stderr output,
+// or ErrExtractionFailed if nothing recognisable was found.
+func classifyYtDlpError(stderr string) string {
+	s := strings.ToLower(stderr)
+	for _, sig := range ytdlpErrorSignatures {
+		if strings.Contains(s, sig.substr) {
+			return sig.code
+		}
+	}
+	return ErrExtractionFailed
+}
+
+// ytdlpError is a failed yt-dlp run, classified from its stderr.
+type ytdlpError struct {
+	Code   string
+	Stderr string
+	Err    error
+}
+
+func (e *ytdlpError) Error() string { return "yt-dlp failed: " + e.Err.Error() }
+
+func (e *ytdlpError) Unwrap() error { return e.Err }
+
+func newYtDlpError(err error, stderr string) *ytdlpError {
+	code := classifyYtDlpError(stderr)
+	if code == ErrExtractionFailed {
+		breakage.record(stderr, time.Now())
+	}
+	return &ytdlpError{Code: code, Stderr: stderr, Err: err}
+}
+
+// ytdlpFailure is newYtDlpError for a run that may have been refused by the
+// process tracker. Those capacity errors are returned as they are: yt-dlp
+// never ran, so they are neither classified nor counted as breakage.
+func ytdlpFailure(err error, stderr string) error {
+	if errors.Is(err, errTooManyProcesses) || errors.Is(err, errTooManyExtractions) {
+		return err
+	}
+	return newYtDlpError(err, stderr)
+}
+
+// writeYtDlpError responds to a failed resolution. Classified yt-dlp errors
+// get their code and, where one helps, a hint for the user.
+func writeYtDlpError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errTooManyProcesses) {
+		writeJSONError(w, http.StatusServiceUnavailable, ErrTooManyProcesses, err.Error())
+		return
+	}
+	if errors.Is(err, errTooManyExtractions) {
+		writeJSONError(w, http.StatusTooManyRequests, ErrTooManyExtractions, err.Error())
+		return
+	}
+	var ye *ytdlpError
+	if !errors.As(err, &ye) {
+		slog.Error("request failed", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	slog.Warn("yt-dlp failed", "code", ye.Code, "err", ytdlpErrorMessage(ye.Stderr))
+	switch ye.Code {
+	case ErrMembersOnly:
+		hint := "this video is for channel members only; cookies from a subscribed account are required (see -cookies)"
+		if cookiesFile != "" || cookiesBrowser != "" {
+			hint = "this video is for channel members only, and the configured cookies are not from a subscribed account"
+		}
+		writeJSONError(w, http.StatusForbidden, ye.Code, hint)
+	case ErrFFmpegMissing:
+		writeJSONError(w, http.StatusUnprocessableEntity, ye.Code, FFMPEG_MISSING_HINT)
+	default:
+		// "exit status 1" alone tells the user nothing, so pass on yt-dlp's
+		// own reason as well
+		writeJSONBody(w, http.StatusInternalServerError, map[string]string{
+			"error":  ye.Error(),
+			"code":   ye.Code,
+			"stderr": ytdlpErrorMessage(ye.Stderr),
+		})
+	}
+}
+
+// MAX_ERROR_MESSAGE caps the yt-dlp message passed on to clients.
+const MAX_ERROR_MESSAGE = 300
+
+// ytdlpErrorMessage returns the first "ERROR:" line from yt-dlp's stderr,
+// without the prefix, for display to the user. Local paths are masked and
+// the result is cut to MAX_ERROR_MESSAGE bytes.
+func ytdlpErrorMessage(stderr string) string {
+	msg := strings.TrimSpace(stderr)
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "ERROR:") {
+			msg = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
+			break
+		}
+	}
+	msg = maskLocalPaths(msg)
+	if len(msg) > MAX_ERROR_MESSAGE {
+		cut := MAX_ERROR_MESSAGE
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		msg = msg[:cut] + "…"
+	}
+	return msg
+}
+
+// maskLocalPaths hides the yt-dlp binary location and the user's home and
+// temp directories, which show up in tracebacks and file errors.
+func maskLocalPaths(s string) string {
+	var dirs []string
+	if bin := ytdlp.Load(); bin != nil {
+		dirs = append(dirs, bin.path, filepath.Dir(bin.path))
+	}
+	dirs = append(dirs, os.TempDir())
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+	for _, dir := range dirs {
+		if len(dir) > 1 {
+			s = strings.ReplaceAll(s, dir, "…")
+		}
+	}
+	return s
+}
+
+// writeJSONError writes a JSON error body with an optional machine-readable code.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	body := map[string]string{"error": message}
+	if code != "" {
+		body["code"] = code
+	}
+	writeJSONBody(w, status, body)
+}
+
+func writeJSONBody(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
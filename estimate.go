@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Defaults for /estimate, overridable with the matching TATATEXT_ env vars.
+const (
+	DEFAULT_BANDWIDTH_MBPS = 10.0  // TATATEXT_ESTIMATE_BANDWIDTH_MBPS
+	DEFAULT_ASR_FACTOR     = 0.15  // TATATEXT_ESTIMATE_ASR_FACTOR: ASR seconds per audio second
+	DEFAULT_AUDIO_KBPS     = 128.0 // TATATEXT_ESTIMATE_AUDIO_KBPS: used when the file size is unknown
+)
+
+var (
+	estimateBandwidthMbps = DEFAULT_BANDWIDTH_MBPS
+	estimateASRFactor     = DEFAULT_ASR_FACTOR
+	estimateAudioKbps     = DEFAULT_AUDIO_KBPS
+)
+
+func loadEstimateConfig() {
+	for name, dst := range map[string]*float64{
+		"TATATEXT_ESTIMATE_BANDWIDTH_MBPS": &estimateBandwidthMbps,
+		"TATATEXT_ESTIMATE_ASR_FACTOR":     &estimateASRFactor,
+		"TATATEXT_ESTIMATE_AUDIO_KBPS":     &estimateAudioKbps,
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			*dst = f
+		} else {
+			log.Printf("ignoring invalid %s %q", name, v)
+		}
+	}
+}
+
+// handleEstimate returns rough download and transcription times for a video,
+// given either ?url= (resolved with yt-dlp for duration and size) or
+// ?duration= in seconds.
+func handleEstimate(w http.ResponseWriter, r *http.Request) {
+	var duration float64
+	var filesize int64
+	if youtubeURL := videoURLParam(r); youtubeURL != "" {
+		audio, err := resolveAudio(r.Context(), youtubeURL)
+		if err != nil {
+			writeYtDlpError(w, err)
+			return
+		}
+		duration, filesize = audio.Duration, audio.Filesize
+	} else if d := r.URL.Query().Get("duration"); d != "" {
+		var err error
+		if duration, err = strconv.ParseFloat(d, 64); err != nil || duration < 0 || math.IsNaN(duration) || math.IsInf(duration, 0) {
+			writeJSONError(w, http.StatusBadRequest, "", "duration must be a number of seconds")
+			return
+		}
+	} else {
+		writeJSONError(w, http.StatusBadRequest, "", "url or duration parameter required")
+		return
+	}
+
+	if filesize <= 0 {
+		filesize = int64(duration * estimateAudioKbps * 1000 / 8)
+	}
+	downloadSeconds := float64(filesize) * 8 / (estimateBandwidthMbps * 1e6)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]float64{
+		"durationSeconds":          duration,
+		"estimatedDownloadSeconds": math.Ceil(downloadSeconds),
+		"estimatedAsrSeconds":      math.Ceil(duration * estimateASRFactor),
+	})
+}
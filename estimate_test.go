@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEstimateDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		status   int
+	}{
+		{"600", http.StatusOK},
+		{"0", http.StatusOK},
+		{"-1", http.StatusBadRequest},
+		{"abc", http.StatusBadRequest},
+		{"NaN", http.StatusBadRequest},
+		{"Inf", http.StatusBadRequest},
+		{"-Inf", http.StatusBadRequest},
+		{"1e309", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleEstimate(rec, httptest.NewRequest(http.MethodGet, "/estimate?duration="+tt.duration, nil))
+		if rec.Code != tt.status {
+			t.Errorf("duration=%s: status %d, want %d", tt.duration, rec.Code, tt.status)
+			continue
+		}
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("duration=%s: body %q is not JSON: %v", tt.duration, rec.Body, err)
+		}
+	}
+}
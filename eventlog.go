@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DEFAULT_EVENT_LOG_MAX_BYTES is the size at which the event log is rotated.
+const DEFAULT_EVENT_LOG_MAX_BYTES = 10 << 20
+
+// downloadEvent is one line of the machine-readable event log.
+type downloadEvent struct {
+	TS         string `json:"ts"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	Bytes      int64  `json:"bytes"`
+	DurationMS int64  `json:"duration_ms"`
+	Status     string `json:"status"`
+}
+
+// eventLog appends download events as JSON lines for external tools to tail.
+// When the file grows past maxBytes it is renamed to <path>.1 and restarted.
+// A nil *eventLog discards events.
+type eventLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+}
+
+var events *eventLog
+
+// openEventLogFromEnv opens TATATEXT_EVENT_LOG if set. The rotation size can
+// be changed with TATATEXT_EVENT_LOG_MAX_BYTES.
+func openEventLogFromEnv() *eventLog {
+	path := os.Getenv("TATATEXT_EVENT_LOG")
+	if path == "" {
+		return nil
+	}
+	l := &eventLog{path: path, maxBytes: DEFAULT_EVENT_LOG_MAX_BYTES}
+	if v := os.Getenv("TATATEXT_EVENT_LOG_MAX_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			l.maxBytes = n
+		} else {
+			log.Printf("ignoring invalid TATATEXT_EVENT_LOG_MAX_BYTES %q", v)
+		}
+	}
+	if err := l.open(); err != nil {
+		log.Printf("event log disabled: %v", err)
+		return nil
+	}
+	log.Printf("writing download events to %s", path)
+	return l
+}
+
+func (l *eventLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.w, l.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+func (l *eventLog) rotate() error {
+	l.w.Flush()
+	l.f.Close()
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		log.Printf("event log rotation failed: %v", err)
+	}
+	return l.open()
+}
+
+// record writes ev as one line and flushes it so tailing tools see it at once.
+func (l *eventLog) record(ev downloadEvent) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.size+int64(len(line)) > l.maxBytes && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			log.Printf("event log disabled: %v", err)
+			l.f = nil
+			return
+		}
+	}
+	n, _ := l.w.Write(line)
+	l.size += int64(n)
+	if err := l.w.Flush(); err != nil {
+		log.Printf("event log write failed: %v", err)
+	}
+}
+
+// recordDownload logs a finished /audio download.
+func (l *eventLog) recordDownload(source, title string, bytes int64, started time.Time, status string) {
+	l.record(downloadEvent{
+		TS:         time.Now().UTC().Format(time.RFC3339),
+		URL:        source,
+		Title:      title,
+		Bytes:      bytes,
+		DurationMS: time.Since(started).Milliseconds(),
+		Status:     status,
+	})
+}
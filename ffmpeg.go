@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ffmpegPath is where probeFFmpeg found ffmpeg at startup, or "".
+var ffmpegPath string
+
+// probeFFmpeg looks for ffmpeg on PATH, where yt-dlp looks for it. It runs
+// once at startup; installing ffmpeg later needs a restart.
+func probeFFmpeg() {
+	p, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		log.Printf("ffmpeg not found on PATH; merged video and conversions are disabled")
+		return
+	}
+	ffmpegPath = p
+	log.Printf("using ffmpeg at %s", p)
+}
+
+// ffmpegAvailable reports whether the startup probe found ffmpeg.
+func ffmpegAvailable() bool {
+	return ffmpegPath != ""
+}
+
+// FFMPEG_MISSING_HINT is the user-facing message for ErrFFmpegMissing.
+const FFMPEG_MISSING_HINT = "this download needs ffmpeg, which is not installed; install ffmpeg and restart the helper"
+
+var (
+	encodersOnce  sync.Once
+	audioEncoders map[string]bool
+)
+
+// ffmpegAudioEncoders returns the audio encoders the installed ffmpeg was
+// built with, detected once. Static builds differ: one without libmp3lame
+// can't write MP3 at all.
+func ffmpegAudioEncoders() map[string]bool {
+	encodersOnce.Do(func() {
+		audioEncoders = map[string]bool{}
+		if !ffmpegAvailable() {
+			return
+		}
+		out, err := exec.Command(ffmpegPath, "-hide_banner", "-encoders").Output()
+		if err != nil {
+			return
+		}
+		audioEncoders = parseFFmpegEncoders(out, 'A')
+	})
+	return audioEncoders
+}
+
+// parseFFmpegEncoders reads `ffmpeg -encoders` output, keeping encoders whose
+// type flag (V, A or S) is kind. Entries follow a " ------" separator line
+// and look like " A....D libmp3lame   libmp3lame MP3 (MPEG audio layer 3)".
+func parseFFmpegEncoders(out []byte, kind byte) map[string]bool {
+	encoders := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	listing := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !listing {
+			listing = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 && fields[0][0] == kind {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+// sortedEncoders lists encoder names for /ping.
+func sortedEncoders(encoders map[string]bool) []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// audioConversion is an output format /audio can re-encode to with convert=.
+type audioConversion struct {
+	encoder     string
+	contentType string
+}
+
+var audioConversions = map[string]audioConversion{
+	"mp3": {encoder: "libmp3lame", contentType: "audio/mpeg"},
+}
+
+// convertAudio re-encodes src to format with ffmpeg as it streams, writing
+// the result to dst. It returns the number of bytes written. The caller
+// holds the process slot, taken before any response headers are sent.
+func convertAudio(ctx context.Context, dst io.Writer, src io.Reader, format string) (int64, error) {
+	out := &countingWriter{w: dst}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-vn", "-c:a", audioConversions[format].encoder,
+		"-f", format, "pipe:1",
+	)
+	cmd.Stdin = src
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out.n, fmt.Errorf("ffmpeg: %w: %s", err, msg)
+		}
+		return out.n, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return out.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// requireEncoder fails up front when a conversion needs an encoder this
+// ffmpeg lacks, rather than midway through the conversion.
+func requireEncoder(name string) error {
+	if !ffmpegAudioEncoders()[name] {
+		return fmt.Errorf("ffmpeg has no %s encoder; install an ffmpeg build that includes it", name)
+	}
+	return nil
+}
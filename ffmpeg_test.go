@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const sampleFFmpegEncoders = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D mpeg4                MPEG-4 part 2
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libmp3lame           libmp3lame MP3 (MPEG audio layer 3) (codec mp3)
+ A....D libopus              libopus Opus (codec opus)
+ A..X.D opus                 Opus
+ S..... webvtt               WebVTT subtitle
+`
+
+func TestParseFFmpegEncoders(t *testing.T) {
+	tests := []struct {
+		kind byte
+		want map[string]bool
+	}{
+		{'A', map[string]bool{"aac": true, "libmp3lame": true, "libopus": true, "opus": true}},
+		{'V', map[string]bool{"libx264": true, "mpeg4": true}},
+		{'S', map[string]bool{"webvtt": true}},
+	}
+	for _, tt := range tests {
+		if got := parseFFmpegEncoders([]byte(sampleFFmpegEncoders), tt.kind); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("kind %c: got %v, want %v", tt.kind, got, tt.want)
+		}
+	}
+}
+
+// The legend above the separator must not be read as encoders, and a build
+// without libmp3lame must show up as such.
+func TestParseFFmpegEncodersWithoutMP3(t *testing.T) {
+	out := "Encoders:\n A..... = Audio\n ------\n A....D aac                  AAC (Advanced Audio Coding)\n"
+	got := parseFFmpegEncoders([]byte(out), 'A')
+	if !reflect.DeepEqual(got, map[string]bool{"aac": true}) {
+		t.Errorf("got %v, want only aac", got)
+	}
+	if got["libmp3lame"] {
+		t.Error("libmp3lame reported for a build without it")
+	}
+	if got := parseFFmpegEncoders(nil, 'A'); len(got) != 0 {
+		t.Errorf("empty output: got %v", got)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// audioFormat is one audio-only entry of /formats.
+type audioFormat struct {
+	FormatID string  `json:"formatId"`
+	Ext      string  `json:"ext"`
+	ABR      float64 `json:"abr,omitempty"`
+	ACodec   string  `json:"acodec"`
+	Filesize int64   `json:"filesize,omitempty"`
+}
+
+// handleFormats lists a video's audio-only formats so the web app can offer
+// a quality choice instead of always taking bestaudio.
+func handleFormats(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := ytdlpCommandContext(r.Context(), "-J", "--no-playlist", "--", youtubeURL)
+	cmd.Stderr = &stderr
+	out, err := outputProcess(cmd)
+	if err != nil {
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+		return
+	}
+	var info struct {
+		Formats []struct {
+			FormatID       string  `json:"format_id"`
+			Ext            string  `json:"ext"`
+			ABR            float64 `json:"abr"`
+			ACodec         string  `json:"acodec"`
+			VCodec         string  `json:"vcodec"`
+			Filesize       float64 `json:"filesize"`
+			FilesizeApprox float64 `json:"filesize_approx"`
+		} `json:"formats"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to parse yt-dlp output: "+err.Error())
+		return
+	}
+
+	formats := []audioFormat{}
+	for _, f := range info.Formats {
+		if f.VCodec != "none" || f.ACodec == "" || f.ACodec == "none" {
+			continue
+		}
+		size := f.Filesize
+		if size == 0 {
+			size = f.FilesizeApprox
+		}
+		formats = append(formats, audioFormat{f.FormatID, f.Ext, f.ABR, f.ACodec, int64(size)})
+	}
+	if len(formats) == 0 {
+		writeJSONError(w, http.StatusNotFound, "", "no audio-only formats available for this video")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"formats": formats})
+}
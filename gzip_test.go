@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipJSON(t *testing.T) {
+	// Every path answers JSON, so only the path decides
+	h := gzipJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"/ping", "gzip", true},
+		{"/ping", "br, gzip;q=0.8", true},
+		{"/ping", "", false},
+		{"/ping", "gzip;q=0", false},
+		{"/audio", "gzip", false},
+		{"/audio", "", false},
+		{"/Audio/", "gzip", false},
+		{"/video", "gzip", false},
+		{"/progress", "gzip", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+			t.Errorf("%s with Accept-Encoding %q: gzipped = %v, want %v", tt.path, tt.acceptEncoding, got, tt.wantGzip)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DEFAULT_URL_TTL is assumed for direct URLs that carry no expire= parameter.
+const DEFAULT_URL_TTL = time.Hour
+
+var (
+	errJobNotFound = errors.New("unknown job token")
+	errJobExpired  = errors.New("job token expired")
+)
+
+// preparedJob is a resolved video waiting for the client to start the download.
+type preparedJob struct {
+	audio     *resolvedAudio
+	expiresAt time.Time
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*preparedJob)
+)
+
+// addJob stores a resolved video and returns its token. The token expires
+// together with the direct URL it points at.
+func addJob(audio *resolvedAudio) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	job := &preparedJob{audio: audio, expiresAt: urlExpiry(audio.URL, time.Now())}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	// Drop jobs that expired long enough ago that nobody will ask again
+	for t, j := range jobs {
+		if time.Since(j.expiresAt) > time.Hour {
+			delete(jobs, t)
+		}
+	}
+	jobs[token] = job
+	return token, nil
+}
+
+// newToken returns a random 128-bit hex identifier.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lookupJob returns the job for token, or errJobNotFound / errJobExpired.
+func lookupJob(token string) (*preparedJob, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[token]
+	if !ok {
+		return nil, errJobNotFound
+	}
+	if time.Now().After(job.expiresAt) {
+		return nil, errJobExpired
+	}
+	return job, nil
+}
+
+// urlExpiry reads the unix timestamp in a googlevideo URL's expire= parameter,
+// falling back to DEFAULT_URL_TTL from now.
+func urlExpiry(directURL string, now time.Time) time.Time {
+	if u, err := url.Parse(directURL); err == nil {
+		if ts, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64); err == nil && ts > 0 {
+			return time.Unix(ts, 0)
+		}
+	}
+	return now.Add(DEFAULT_URL_TTL)
+}
+
+// handlePrepare resolves a video and returns a job token that /audio?job=
+// accepts, so the UI can show title and size before starting the download.
+func handlePrepare(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	audio, err := resolveAudio(r.Context(), youtubeURL)
+	if err != nil {
+		writeYtDlpError(w, err)
+		return
+	}
+	token, err := addJob(audio)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to create job: "+err.Error())
+		return
+	}
+
+	// The job expires with the direct URL; clients can re-prepare just before
+	expiresAt := urlExpiry(audio.URL, time.Now()).Unix()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Url-Expires", strconv.FormatInt(expiresAt, 10))
+	json.NewEncoder(w).Encode(struct {
+		Job       string  `json:"job"`
+		Title     string  `json:"title"`
+		Duration  float64 `json:"duration,omitempty"`
+		Filesize  int64   `json:"filesize,omitempty"`
+		ExpiresAt int64   `json:"expiresAt"`
+	}{token, audio.Title, audio.Duration, audio.Filesize, expiresAt})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestURLExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name, url string
+		want      time.Time
+	}{
+		{"expire param", "https://rr1---sn-abc.googlevideo.com/videoplayback?expire=1700021600&itag=140", time.Unix(1700021600, 0)},
+		{"no expire", "https://rr1---sn-abc.googlevideo.com/videoplayback?itag=140", now.Add(DEFAULT_URL_TTL)},
+		{"garbage expire", "https://example.com/a.m4a?expire=soon", now.Add(DEFAULT_URL_TTL)},
+		{"zero expire", "https://example.com/a.m4a?expire=0", now.Add(DEFAULT_URL_TTL)},
+		{"unparseable URL", "://bad", now.Add(DEFAULT_URL_TTL)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := urlExpiry(tt.url, now); !got.Equal(tt.want) {
+				t.Errorf("urlExpiry(%q) = %s, want %s", tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// flushWriter flushes after every write so live data reaches the client as
+// it is produced, and counts what was written.
+type flushWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	n       int64
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.n += int64(n)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+// handleLive streams a currently-live video as yt-dlp records it. The
+// response is chunked (no Content-Length) and ends when the stream ends;
+// if the client disconnects, yt-dlp is killed via the request context.
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	// Refuse VODs and upcoming streams up front
+	var stderr bytes.Buffer
+	probe := ytdlpCommandContext(r.Context(),
+		"--simulate",
+		"--no-playlist",
+		"--print", "%(is_live)s\n%(title)s",
+		"--",
+		youtubeURL,
+	)
+	probe.Stderr = &stderr
+	out, err := outputProcess(probe)
+	if err != nil {
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+		return
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)
+	if lines[0] != "True" {
+		writeJSONError(w, http.StatusBadRequest, ErrNotLive, "this video is not currently live; use /audio for recorded videos")
+		return
+	}
+	title := "YouTube Live"
+	if len(lines) == 2 && lines[1] != "" {
+		title = lines[1]
+	}
+
+	args := []string{"--no-playlist", "--quiet", "--no-progress", "-f", "bestaudio/best", "-o", "-"}
+	if r.URL.Query().Get("fromStart") == "1" {
+		args = append(args, "--live-from-start")
+	}
+	stderr.Reset()
+	cmd := ytdlpCommandContext(r.Context(), append(args, "--", youtubeURL)...)
+	cmd.Stderr = &stderr
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Video-Title", title)
+	fw := &flushWriter{w: w}
+	fw.flusher, _ = w.(http.Flusher)
+	cmd.Stdout = fw
+
+	err = runProcess(cmd)
+	switch {
+	case r.Context().Err() != nil:
+		log.Printf("live stream client disconnected after %d bytes", fw.n)
+	case err != nil && fw.n == 0:
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+	case err != nil:
+		log.Printf("live stream failed after %d bytes: %v", fw.n, err)
+		panic(http.ErrAbortHandler)
+	default:
+		log.Printf("live stream ended after %d bytes", fw.n)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+)
+
+// setupLogging sends all output, including plain log.Printf calls, through a
+// JSON slog handler at the given level (debug, info, warn or error). Plain
+// log.Printf lines are logged at info.
+func setupLogging(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: use debug, info, warn or error", level)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+	return nil
+}
+
+// recordDownload logs a finished /audio download, counts it in the usage
+// stats and appends it to the event log, if one is configured.
+func recordDownload(source, title string, bytes int64, started time.Time, status string) {
+	host := source
+	if u, err := url.Parse(source); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	level := slog.LevelInfo
+	if status == "failed" {
+		level = slog.LevelError
+	}
+	slog.Log(context.Background(), level, "audio download "+status,
+		"host", host,
+		"title", title,
+		"bytes", bytes,
+		"duration_ms", time.Since(started).Milliseconds(),
+	)
+	usage.recordDownload(bytes, status)
+	events.recordDownload(source, title, bytes, started, status)
+}
@@ -1,151 +1,1180 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-//go:embed yt-dlp-mac yt-dlp-win.exe
+//go:embed yt-dlp-mac yt-dlp-win.exe yt-dlp-linux
 var embeddedBinaries embed.FS
 
 const (
-	PORT        = 7337
-	YTDLP_REPO  = "yt-dlp/yt-dlp"
-	CONFIG_DIR  = "tatatext-helper"
+	PORT       = 7337
+	YTDLP_REPO = "yt-dlp/yt-dlp"
+	CONFIG_DIR = "tatatext-helper"
+
+	// Stable public video used to warm yt-dlp's player cache ("Me at the zoo")
+	DEFAULT_WARMUP_VIDEO = "https://www.youtube.com/watch?v=jNQXAC9IVRw"
+
+	UPDATE_AUTO   = "auto"   // check and install updates
+	UPDATE_NOTIFY = "notify" // check and announce, install via /update
+	UPDATE_MANUAL = "manual" // no periodic checks, install via /update
+
+	DEFAULT_USER_AGENT = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36"
+
+	// Response headers the web app reads from fetch(); browsers hide
+	// non-safelisted headers from cross-origin JS unless listed here
+	EXPOSED_HEADERS = "Content-Disposition, X-Video-Title, X-Video-Extension, X-Url-Expires, X-Request-Id, Content-Range"
 )
 
+// ytdlpBinary is an installed yt-dlp and its version. Values are never
+// modified; an update stores a new one in ytdlp, so readers need no lock.
+type ytdlpBinary struct {
+	path    string
+	version string
+}
+
+var ytdlp atomic.Pointer[ytdlpBinary]
+
 var (
-	ytdlpPath    string
-	ytdlpVersion string
-	updateMu     sync.Mutex
+	availableVersion string // newer release found but not installed
+	lastUpdateError  string
+	ready            bool // the active yt-dlp binary runs
+	updateMode       string
+	ytdlpCacheDir    string
+	userAgent        string
+	allowedExts      []string
+	sourceAddress    string
+	cookiesFile      string            // Netscape cookie file for yt-dlp --cookies
+	cookiesBrowser   string            // browser for yt-dlp --cookies-from-browser
+	warmupVideo      string            // empty unless TATATEXT_WARMUP=1
+	proxyTransport   http.RoundTripper = http.DefaultTransport
+	updatePaused     bool              // set by /update/pause
+	systemYtDlp      bool              // -ytdlp-path/YTDLP_PATH given: never extract or update
+	pinnedVersion    string            // -ytdlp-version: update to this tag instead of the latest
+	asciiFilenames   bool              // TATATEXT_ASCII_FILENAMES=1
+	updateMu         sync.Mutex        // guards availableVersion, lastUpdateError, ready, updatePaused
+	checkMu          sync.Mutex        // serialises checkAndUpdate
 )
 
+// updateControl wakes the update loop after /update/pause or /update/resume.
+var updateControl = make(chan struct{}, 1)
+
 func main() {
-	ytdlpPath = extractYtDlp()
-	ytdlpVersion = getYtDlpVersion(ytdlpPath)
-	log.Printf("yt-dlp version: %s", ytdlpVersion)
+	portFlag := flag.Int("port", 0, "port to listen on (overrides TATATEXT_PORT, default 7337)")
+	idleFlag := flag.Duration("download-idle-timeout", 0, "cancel an upstream download after this long without data (overrides TATATEXT_DOWNLOAD_IDLE_TIMEOUT, default 60s)")
+	logLevel := flag.String("log-level", "", "debug, info, warn or error (overrides TATATEXT_LOG_LEVEL, default info)")
+	corsFlag := flag.String("cors-origins", "", "extra comma-separated origins allowed to call the helper, e.g. http://localhost:3000 (overrides TATATEXT_CORS_ORIGINS; https://tatatext.com is always allowed)")
+	cookiesFlag := flag.String("cookies", "", "Netscape-format cookie file passed to yt-dlp, for age-restricted and members-only videos (overrides TATATEXT_COOKIES)")
+	cookiesBrowserFlag := flag.String("cookies-browser", "", "browser to load cookies from, e.g. chrome or firefox (overrides TATATEXT_COOKIES_BROWSER)")
+	versionFlag := flag.String("ytdlp-version", "", "keep yt-dlp at this release tag, e.g. 2024.08.06, instead of tracking the latest; may downgrade (overrides TATATEXT_YTDLP_VERSION)")
+	ytdlpPathFlag := flag.String("ytdlp-path", "", "use this yt-dlp binary instead of the embedded one, without auto-updates (overrides YTDLP_PATH)")
+	flag.Parse()
+
+	level := *logLevel
+	if level == "" {
+		level = os.Getenv("TATATEXT_LOG_LEVEL")
+	}
+	if level == "" {
+		level = "info"
+	}
+	if err := setupLogging(level); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	notifier = newNotifierFromEnv()
+
+	// A system-installed yt-dlp replaces the embedded one and its updates
+	systemPath := *ytdlpPathFlag
+	if systemPath == "" {
+		systemPath = os.Getenv("YTDLP_PATH")
+	}
+	var bin string
+	if systemPath != "" {
+		if _, err := os.Stat(systemPath); err != nil {
+			log.Fatalf("yt-dlp path %s: %v", systemPath, err)
+		}
+		if getYtDlpVersion(systemPath) == "unknown" {
+			log.Fatalf("yt-dlp at %s does not run (`%s --version` failed); check the path and that it is executable", systemPath, systemPath)
+		}
+		bin = systemPath
+		systemYtDlp = true
+		log.Printf("using system yt-dlp at %s; automatic updates disabled", bin)
+	} else {
+		bin = extractYtDlp()
+	}
+	ytdlp.Store(&ytdlpBinary{path: bin, version: getYtDlpVersion(bin)})
+
+	pinnedVersion = os.Getenv("TATATEXT_YTDLP_VERSION")
+	if *versionFlag != "" {
+		pinnedVersion = *versionFlag
+	}
+	if pinnedVersion != "" {
+		if _, ok := parseYtDlpVersion(pinnedVersion); !ok {
+			log.Fatalf("invalid yt-dlp version %q: use a release tag like 2024.08.06", pinnedVersion)
+		}
+		log.Printf("yt-dlp pinned to %s", pinnedVersion)
+	}
+	ready = ytdlp.Load().version != "unknown"
+	log.Printf("yt-dlp version: %s", ytdlp.Load().version)
+
+	// Keep yt-dlp's player cache next to our binary so it can be cleared
+	ytdlpCacheDir = os.Getenv("TATATEXT_YTDLP_CACHE")
+	if ytdlpCacheDir == "" {
+		ytdlpCacheDir = defaultCacheDir()
+	}
+
+	// yt-dlp and the proxy must present the same User-Agent: YouTube can tie
+	// the direct URL to the client that extracted it and 403 a mismatch.
+	userAgent = os.Getenv("TATATEXT_USER_AGENT")
+	if userAgent == "" {
+		userAgent = DEFAULT_USER_AGENT
+	}
+
+	// Route yt-dlp and the proxy through a specific local interface
+	if sourceAddress = os.Getenv("TATATEXT_SOURCE_ADDRESS"); sourceAddress != "" {
+		t, err := sourceAddressTransport(sourceAddress)
+		if err != nil {
+			log.Fatalf("invalid TATATEXT_SOURCE_ADDRESS: %v", err)
+		}
+		proxyTransport = t
+		log.Printf("binding outgoing connections to %s", sourceAddress)
+	}
+
+	// Signed-in cookies let yt-dlp fetch age-restricted and members-only videos
+	cookiesFile = os.Getenv("TATATEXT_COOKIES")
+	if *cookiesFlag != "" {
+		cookiesFile = *cookiesFlag
+	}
+	if cookiesFile != "" {
+		if _, err := os.Stat(cookiesFile); err != nil {
+			log.Printf("warning: ignoring cookie file: %v", err)
+			cookiesFile = ""
+		} else {
+			log.Printf("passing cookies from %s to yt-dlp", cookiesFile)
+		}
+	}
+	cookiesBrowser = os.Getenv("TATATEXT_COOKIES_BROWSER")
+	if *cookiesBrowserFlag != "" {
+		cookiesBrowser = *cookiesBrowserFlag
+	}
+	if cookiesBrowser != "" {
+		log.Printf("passing cookies from %s to yt-dlp", cookiesBrowser)
+	}
 
-	// Auto-update yt-dlp in background
-	go autoUpdateYtDlp()
+	// Optional output contract for /audio, e.g. "m4a,mp3,opus"
+	for _, ext := range strings.Split(os.Getenv("TATATEXT_ALLOWED_EXTS"), ",") {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+			allowedExts = append(allowedExts, ext)
+		}
+	}
+
+	updateMode = os.Getenv("TATATEXT_UPDATE_MODE")
+	switch updateMode {
+	case UPDATE_AUTO, UPDATE_NOTIFY, UPDATE_MANUAL:
+	case "":
+		updateMode = UPDATE_AUTO
+	default:
+		log.Printf("unknown TATATEXT_UPDATE_MODE %q, using %s", updateMode, UPDATE_AUTO)
+		updateMode = UPDATE_AUTO
+	}
 
-	mux := http.NewServeMux()
+	events = openEventLogFromEnv()
+	loadEstimateConfig()
+	loadProcessLimit()
+	loadBreakageConfig()
+
+	policy = loadPolicy()
+	if *idleFlag > 0 {
+		policy.DownloadIdleTimeout = *idleFlag
+	}
+	log.Printf("policy: %+v", policy)
+
+	asciiFilenames = os.Getenv("TATATEXT_ASCII_FILENAMES") == "1"
+	adminToken = os.Getenv("TATATEXT_ADMIN_TOKEN")
+	probeFFmpeg()
+
+	// TATATEXT_READINESS_PROBE=extract: /health stays 503 until a real
+	// extraction of the warm-up video succeeds
+	readinessProbe = os.Getenv("TATATEXT_READINESS_PROBE")
+	if readinessProbe == "extract" {
+		video := os.Getenv("TATATEXT_WARMUP_VIDEO")
+		if video == "" {
+			video = DEFAULT_WARMUP_VIDEO
+		}
+		go probeExtraction(ctx, video)
+	} else if readinessProbe != "" {
+		log.Printf("ignoring unknown TATATEXT_READINESS_PROBE %q", readinessProbe)
+		readinessProbe = ""
+	}
+
+	// Optionally fetch the player JS now so the first real request is fast
+	if os.Getenv("TATATEXT_WARMUP") == "1" {
+		warmupVideo = os.Getenv("TATATEXT_WARMUP_VIDEO")
+		if warmupVideo == "" {
+			warmupVideo = DEFAULT_WARMUP_VIDEO
+		}
+		go warmUpYtDlp()
+	}
+
+	// Auto-update yt-dlp in background, and keep checking the binary still runs
+	go autoUpdateYtDlp(ctx)
+	go selfCheckYtDlp(ctx)
+
+	mux := newRouter()
 
 	// Health check + version info
 	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "https://tatatext.com")
 		w.Header().Set("Content-Type", "application/json")
+		v := ytdlp.Load().version
 		updateMu.Lock()
-		v := ytdlpVersion
+		available := availableVersion
+		updateErr := lastUpdateError
+		isReady := ready
+		paused := updatePaused
 		updateMu.Unlock()
-		json.NewEncoder(w).Encode(map[string]string{
-			"status":       "ok",
-			"version":      "1.0.0",
-			"ytdlpVersion": v,
-		})
+		info := map[string]any{
+			"status":          "ok",
+			"ready":           isReady,
+			"version":         "1.0.0",
+			"ytdlpVersion":    v,
+			"userAgent":       userAgent,
+			"updateMode":      updateMode,
+			"updateAvailable": available != "",
+			"updatePaused":    paused,
+		}
+		if pinnedVersion != "" {
+			info["pinnedVersion"] = pinnedVersion
+		}
+		if available != "" {
+			info["availableVersion"] = available
+		}
+		if updateErr != "" {
+			info["lastUpdateError"] = updateErr
+		}
+		info["processes"] = procs.stats()
+		info["usage"] = usage.snapshot()
+		info["ffmpegAvailable"] = ffmpegAvailable()
+		info["capabilities"] = map[string]any{
+			"audioEncoders": sortedEncoders(ffmpegAudioEncoders()),
+		}
+		json.NewEncoder(w).Encode(info)
 	})
 
+	// Readiness for load balancers: 503 until yt-dlp is usable
+	mux.HandleFunc("/health", handleHealth)
+
 	// Audio download
-	mux.HandleFunc("/audio", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "https://tatatext.com")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
+	mux.HandleFunc("/audio", handleAudio)
+
+	// Downloadability check without downloading
+	mux.HandleFunc("/check", handleCheck)
+
+	// Clear yt-dlp's cache (fixes some 403s caused by a stale player cache)
+	mux.HandleFunc("/cache", adminOnly(handleCache))
+
+	// Check for and install a yt-dlp update now, regardless of update mode
+	mux.HandleFunc("/update", adminOnly(handleUpdate))
+
+	// Freeze and unfreeze automatic updates
+	mux.HandleFunc("/update/pause", adminOnly(handleUpdatePause))
+	mux.HandleFunc("/update/resume", adminOnly(handleUpdateResume))
+
+	// Resolve now, download later via /audio?job=
+	mux.HandleFunc("/prepare", handlePrepare)
+
+	// Stream a currently-live video as it is recorded
+	mux.HandleFunc("/live", handleLive)
+
+	// Rough download/transcription time estimate
+	mux.HandleFunc("/estimate", handleEstimate)
+
+	// Storyboard sprite sheets for scrubbing previews
+	mux.HandleFunc("/storyboard", handleStoryboard)
+
+	// Title, duration, uploader and thumbnail for a preview card
+	mux.HandleFunc("/metadata", handleMetadata)
+
+	// Captions for one language, as VTT or SRT
+	mux.HandleFunc("/subtitles", handleSubtitles)
+
+	// Audio-only formats, for a quality picker
+	mux.HandleFunc("/formats", handleFormats)
+
+	// Full video as mp4, merged with ffmpeg when available
+	mux.HandleFunc("/video", handleVideo)
+
+	// Server-Sent Events for an /audio download's progress
+	mux.HandleFunc("/progress", handleProgress)
+
+	port := PORT
+	if v := os.Getenv("TATATEXT_PORT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n < 65536 {
+			port = n
+		} else {
+			log.Printf("ignoring invalid TATATEXT_PORT %q", v)
+		}
+	}
+	if *portFlag > 0 {
+		port = *portFlag
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	limiter := newOriginLimiterFromEnv()
+	ln, err := net.Listen("tcp", addr)
+	if isAddrInUse(err) {
+		log.Fatalf("port %d is already in use; is another helper running? Pick a different one with -port or TATATEXT_PORT", port)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("tatatext helper running on http://%s", addr)
+	showNotification("tatatext Helper", "Running in background — YouTube transcription is now enabled.")
+	// TATATEXT_LOOPBACK_CHECK=0 skips the startup self-request to /ping
+	if os.Getenv("TATATEXT_LOOPBACK_CHECK") != "0" {
+		go checkLoopback(addr)
+	}
+
+	corsOrigins := os.Getenv("TATATEXT_CORS_ORIGINS")
+	if *corsFlag != "" {
+		corsOrigins = *corsFlag
+	}
+	cors := newCORSPolicy(corsOrigins)
+
+	srv := &http.Server{Handler: cors.middleware(limiter.middleware(gzipJSON(mux)))}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		stop() // a second Ctrl-C exits immediately
+		log.Printf("shutting down, giving active downloads up to %s to finish", policy.ShutdownGrace)
+		graceCtx, cancel := context.WithTimeout(context.Background(), policy.ShutdownGrace)
+		defer cancel()
+		if err := srv.Shutdown(graceCtx); err != nil {
+			log.Printf("grace period over, closing remaining connections: %v", err)
+			srv.Close()
+		}
+	}()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+	log.Println("stopped")
+}
+
+// handleAudio proxies a video's audio stream, resolved from url= or taken
+// from a /prepare job=, with the title and extension in the headers.
+func handleAudio(w http.ResponseWriter, r *http.Request) {
+	usage.requests.Add(1)
+	started := time.Now()
+	slog.Debug("audio request", "url", r.URL.Query().Get("url"), "job", r.URL.Query().Get("job"))
+
+	// convert=mp3 re-encodes through ffmpeg. The output can't be seeked
+	// into, so a Range header is ignored.
+	rangeHeader := r.Header.Get("Range")
+	convertTo := r.URL.Query().Get("convert")
+	if convertTo != "" {
+		conv, ok := audioConversions[convertTo]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "", "unsupported convert value "+strconv.Quote(convertTo)+", use mp3")
+			return
+		}
+		if !ffmpegAvailable() {
+			writeJSONError(w, http.StatusUnprocessableEntity, ErrFFmpegMissing, FFMPEG_MISSING_HINT)
+			return
+		}
+		if err := requireEncoder(conv.encoder); err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, ErrEncoderUnavailable, err.Error())
 			return
 		}
+		rangeHeader = ""
+	}
+
+	// start= and end= download just that part, which yt-dlp cuts with ffmpeg
+	section, err := parseSection(r.URL.Query().Get("start"), r.URL.Query().Get("end"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	if section != "" && !ffmpegAvailable() {
+		writeJSONError(w, http.StatusUnprocessableEntity, ErrFFmpegMissing, FFMPEG_MISSING_HINT)
+		return
+	}
 
-		youtubeURL := r.URL.Query().Get("url")
+	// Either a token from /prepare or a URL to resolve now
+	var audio *resolvedAudio
+	selector := audioFormatSelector()
+	token := r.URL.Query().Get("job")
+	if token != "" {
+		job, err := lookupJob(token)
+		if err == errJobExpired {
+			writeJSONError(w, http.StatusGone, "", "job expired, call /prepare again")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusNotFound, "", err.Error())
+			return
+		}
+		if section != "" {
+			serveAudioSection(w, r, job.audio.Source, selector, section, convertTo)
+			return
+		}
+		audio = job.audio
+	} else {
+		youtubeURL := videoURLParam(r)
 		if youtubeURL == "" {
 			w.Header().Set("Content-Type", "application/json")
 			http.Error(w, `{"error":"url parameter required"}`, http.StatusBadRequest)
 			return
 		}
+		if f := r.URL.Query().Get("format"); f != "" {
+			if !formatSelectorPattern.MatchString(f) {
+				writeJSONError(w, http.StatusBadRequest, "", "invalid format selector")
+				return
+			}
+			selector = f
+		}
+		if section != "" {
+			serveAudioSection(w, r, youtubeURL, selector, section, convertTo)
+			return
+		}
+		if audio, err = resolveFormat(r.Context(), youtubeURL, selector); err != nil {
+			writeYtDlpError(w, err)
+			return
+		}
+	}
 
-		updateMu.Lock()
-		bin := ytdlpPath
-		updateMu.Unlock()
-
-		// Single yt-dlp call: get title + URL together via --print
-		cmd := exec.Command(bin,
-			"--no-playlist",
-			"-f", "bestaudio[ext=m4a]/bestaudio",
-			"--print", "%(title)s\n%(url)s",
-			"--",
-			youtubeURL,
-		)
-		out, err := cmd.Output()
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, fmt.Sprintf(`{"error":"yt-dlp failed: %s"}`, err.Error()), http.StatusInternalServerError)
+	// Proxy the audio stream to the browser
+	resp, body, err := fetchAudio(r.Context(), audio.URL, rangeHeader)
+	if errors.Is(err, errUpstreamNotMedia) {
+		if token != "" {
+			writeJSONError(w, http.StatusGone, "", "direct URL no longer valid, call /prepare again")
 			return
 		}
-		lines := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)
-		title := "YouTube Video"
-		if len(lines) >= 1 && lines[0] != "" {
-			title = lines[0]
+		// Usually an expired or blocked URL; a fresh one normally works
+		log.Printf("direct URL returned a web page, re-resolving %s", audio.Source)
+		if audio, err = resolveFormat(r.Context(), audio.Source, selector); err != nil {
+			writeYtDlpError(w, err)
+			return
 		}
-		if len(lines) < 2 || lines[1] == "" {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error":"no audio URL found"}`, http.StatusInternalServerError)
+		resp, body, err = fetchAudio(r.Context(), audio.URL, rangeHeader)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, fmt.Sprintf(`{"error":"download failed: %s"}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		w.Header().Set("Content-Range", resp.Header.Get("Content-Range"))
+		writeJSONError(w, http.StatusRequestedRangeNotSatisfiable, "", "requested range not satisfiable")
+		return
+	}
+	title := audio.Title
+
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "audio/mp4"
+	}
+	if convertTo != "" {
+		ct = audioConversions[convertTo].contentType
+	}
+	ext := outputExtension(convertTo, audio.Ext, ct)
+
+	if !extAllowed(ext) {
+		to := ""
+		if convertTo == "" {
+			to = fallbackConversion()
+		}
+		if to == "" {
+			writeExtNotAllowed(w, ext)
 			return
 		}
-		audioURL := strings.TrimSpace(lines[1])
+		log.Printf("%s is not an allowed output format, converting to %s", ext, to)
+		convertTo, ct, ext = to, audioConversions[to].contentType, to
+		if resp.StatusCode == http.StatusPartialContent {
+			// Converted output can't be seeked into, so start from the top
+			resp.Body.Close()
+			if resp, body, err = fetchAudio(r.Context(), audio.URL, ""); err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "", "download failed: "+err.Error())
+				return
+			}
+			defer resp.Body.Close()
+		}
+	}
 
-		// Proxy the audio stream to the browser
-		req, _ := http.NewRequest("GET", audioURL, nil)
-		req.Header.Set("User-Agent", "Mozilla/5.0")
-		client := &http.Client{Timeout: 5 * time.Minute}
-		resp, err := client.Do(req)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, fmt.Sprintf(`{"error":"download failed: %s"}`, err.Error()), http.StatusInternalServerError)
+	// Take ffmpeg's process slot while an error can still be sent
+	if convertTo != "" {
+		if err := procs.acquire(); err != nil {
+			writeYtDlpError(w, err)
 			return
 		}
-		defer resp.Body.Close()
+		defer procs.release()
+	}
+
+	// The upstream length and ranges don't describe converted output
+	if convertTo == "" {
+		copyUpstreamHeaders(w.Header(), resp.Header)
+	}
+	w.Header().Set("Content-Type", ct)
+	setDownloadHeaders(w.Header(), title, ext)
+
+	// Progress for /progress?id=, sized from the upstream response
+	total := resp.ContentLength
+	if total < 0 && audio.Filesize > 0 {
+		total = audio.Filesize
+	}
+	reqID, prog, err := startProgress(total)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to create request id: "+err.Error())
+		return
+	}
+	w.Header().Set("X-Request-Id", reqID)
 
-		safeTitle := sanitizeFilename(title)
-		ct := resp.Header.Get("Content-Type")
-		if ct == "" {
-			ct = "audio/mp4"
+	// hash=sha256 sends a SHA-256 of the file as a trailer, so callers can
+	// skip media they have already processed. Partial and converted
+	// responses don't match the source file and get none.
+	var hasher hash.Hash
+	if r.URL.Query().Get("hash") == "sha256" && resp.StatusCode != http.StatusPartialContent && convertTo == "" {
+		hasher = sha256.New()
+		body = io.TeeReader(body, hasher)
+		w.Header().Set("Trailer", "X-Content-SHA256")
+		// net/http only sends trailers on chunked responses, which a
+		// Content-Length would prevent
+		w.Header().Del("Content-Length")
+	}
+
+	// Seeking in an <audio> element sends Range; pass the partial answer on
+	if resp.StatusCode == http.StatusPartialContent {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	var n int64
+	if convertTo != "" {
+		// Progress follows the source, whose size is known
+		n, err = convertAudio(r.Context(), w, io.TeeReader(body, progressWriter{io.Discard, prog}), convertTo)
+	} else {
+		n, err = io.Copy(progressWriter{w, prog}, body)
+	}
+	if r.Context().Err() != nil {
+		// The upstream request shares the context, so it is already torn down
+		prog.finish(reqID, "cancelled")
+		recordDownload(audio.Source, title, n, started, "cancelled")
+		log.Printf("audio client disconnected after %d bytes", n)
+		return
+	}
+	if err != nil || (convertTo == "" && resp.ContentLength >= 0 && n < resp.ContentLength) {
+		prog.finish(reqID, "failed")
+		recordDownload(audio.Source, title, n, started, "failed")
+		if convertTo == "" && resp.ContentLength >= 0 {
+			log.Printf("audio proxy truncated: copied %d of %d bytes (%d short): %v", n, resp.ContentLength, resp.ContentLength-n, err)
+		} else {
+			log.Printf("audio proxy interrupted after %d bytes: %v", n, err)
 		}
-		ext := "m4a"
-		if strings.Contains(ct, "webm") || strings.Contains(ct, "ogg") {
-			ext = "webm"
+		// Reset the connection instead of ending cleanly, so the browser
+		// treats this as a failed download rather than a complete file.
+		panic(http.ErrAbortHandler)
+	}
+	if hasher != nil {
+		w.Header().Set("X-Content-SHA256", hex.EncodeToString(hasher.Sum(nil)))
+	}
+	prog.finish(reqID, "completed")
+	recordDownload(audio.Source, title, n, started, "completed")
+}
+
+// isAddrInUse reports whether a listen error means the port is taken. Windows
+// reports WSAEADDRINUSE (10048) rather than syscall.EADDRINUSE.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.Errno(10048))
+}
+
+// handleCheck resolves a URL with --simulate and reports whether it can be
+// downloaded, so the web app can validate a pasted link up front.
+func handleCheck(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := ytdlpCommandContext(r.Context(),
+		"--simulate",
+		"--no-playlist",
+		"--print", "%(title)s\n%(duration)s",
+		"--",
+		youtubeURL,
+	)
+	cmd.Stderr = &stderr
+	out, err := outputProcess(cmd)
+	if errors.Is(err, errTooManyProcesses) || errors.Is(err, errTooManyExtractions) {
+		writeYtDlpError(w, err)
+		return
+	}
+
+	result := struct {
+		Downloadable bool    `json:"downloadable"`
+		Reason       string  `json:"reason,omitempty"`
+		Message      string  `json:"message,omitempty"`
+		Title        string  `json:"title,omitempty"`
+		Duration     float64 `json:"duration,omitempty"`
+	}{}
+	if err != nil {
+		result.Reason = newYtDlpError(err, stderr.String()).Code
+		result.Message = ytdlpErrorMessage(stderr.String())
+	} else {
+		lines := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)
+		result.Downloadable = true
+		result.Title = lines[0]
+		if len(lines) == 2 {
+			result.Duration, _ = strconv.ParseFloat(strings.TrimSpace(lines[1]), 64)
 		}
+	}
 
-		w.Header().Set("Content-Type", ct)
-		if cl := resp.Header.Get("Content-Length"); cl != "" {
-			w.Header().Set("Content-Length", cl)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleCache clears yt-dlp's cache directory on POST.
+func handleCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "", "use POST to clear the cache")
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := ytdlpCommand("--rm-cache-dir")
+	cmd.Stderr = &stderr
+	if err := runProcess(cmd); errors.Is(err, errTooManyProcesses) {
+		writeYtDlpError(w, err)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to clear cache: "+ytdlpErrorMessage(stderr.String()))
+		return
+	}
+	log.Printf("cleared yt-dlp cache at %s", ytdlpCacheDir)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "cleared"})
+}
+
+// forwardedUpstreamHeaders are the only upstream response headers the proxy
+// passes on. Everything else (Set-Cookie, caching directives, YouTube's own
+// headers) stays between us and YouTube.
+var forwardedUpstreamHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"Last-Modified",
+}
+
+func copyUpstreamHeaders(dst, src http.Header) {
+	for _, k := range forwardedUpstreamHeaders {
+		if v := src.Get(k); v != "" {
+			dst.Set(k, v)
 		}
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, safeTitle, ext))
-		w.Header().Set("X-Video-Title", title)
-		w.Header().Set("X-Video-Extension", ext)
-		io.Copy(w, resp.Body)
-	})
+	}
+}
 
-	addr := fmt.Sprintf("127.0.0.1:%d", PORT)
-	log.Printf("tatatext helper running on http://%s", addr)
-	showNotification("tatatext Helper", "Running in background — YouTube transcription is now enabled.")
+var errUpstreamNotMedia = errors.New("direct URL returned a web page instead of media")
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatal(err)
+// fetchAudio starts the upstream download of a direct URL, forwarding the
+// client's Range header if any. When the upstream Content-Type isn't audio or
+// video, the first 512 bytes are sniffed so an HTML error page is rejected
+// instead of being proxied as audio.
+func fetchAudio(ctx context.Context, audioURL, rangeHeader string) (*http.Response, io.Reader, error) {
+	// No overall deadline: a multi-hour podcast may take a long time, only a
+	// connection that stops sending for DownloadIdleTimeout is cancelled, or
+	// one whose client has gone away (ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	idle := &idleTimeoutBody{timeout: policy.DownloadIdleTimeout, cancel: cancel}
+	idle.timer = time.AfterFunc(idle.timeout, idle.expire)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", audioURL, nil)
+	req.Header.Set("User-Agent", userAgent)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	client := &http.Client{Transport: proxyTransport, CheckRedirect: keepProxyHeaders}
+	resp, err := client.Do(req)
+	if err != nil {
+		idle.stop()
+		return nil, nil, idle.wrap(err)
+	}
+	idle.body = resp.Body
+	resp.Body = idle
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return resp, resp.Body, nil
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(ct, "audio/") || strings.HasPrefix(ct, "video/") {
+		return resp, resp.Body, nil
+	}
+	br := bufio.NewReader(resp.Body)
+	head, _ := br.Peek(512)
+	if strings.HasPrefix(http.DetectContentType(head), "text/html") {
+		resp.Body.Close()
+		return nil, nil, errUpstreamNotMedia
+	}
+	return resp, br, nil
+}
+
+// MAX_PROXY_REDIRECTS bounds redirect chains from the media CDN.
+const MAX_PROXY_REDIRECTS = 5
+
+// keepProxyHeaders is the proxy client's CheckRedirect. CDN redirects can
+// lead to hosts that reject requests without our User-Agent or that must see
+// the Range, so both are carried over explicitly on every hop.
+func keepProxyHeaders(req *http.Request, via []*http.Request) error {
+	if len(via) >= MAX_PROXY_REDIRECTS {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	for _, h := range []string{"User-Agent", "Range"} {
+		if v := via[0].Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	log.Printf("upstream redirect %d: %s -> %s", len(via), via[len(via)-1].URL.Host, req.URL.Host)
+	return nil
+}
+
+// idleTimeoutBody cancels a download when a read waits longer than timeout
+// for upstream data. The timer only runs inside Read: a client that stops
+// draining the proxy (a paused <audio> with a full buffer) isn't idleness.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func (b *idleTimeoutBody) expire() {
+	b.expired.Store(true)
+	b.cancel()
+}
+
+func (b *idleTimeoutBody) stop() {
+	b.timer.Stop()
+	b.cancel()
+}
+
+// wrap explains a cancellation caused by the idle timer.
+func (b *idleTimeoutBody) wrap(err error) error {
+	if err != nil && b.expired.Load() {
+		return fmt.Errorf("no data received for %s: %w", b.timeout, err)
+	}
+	return err
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	return n, b.wrap(err)
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.stop()
+	return b.body.Close()
+}
+
+// resolvedAudio is what yt-dlp reports about a video's best audio stream.
+type resolvedAudio struct {
+	Source   string // the URL that was resolved
+	Title    string
+	URL      string
+	Duration float64
+	Filesize int64
+	Ext      string // as reported by yt-dlp, unsanitized
+}
+
+// resolveFields is the number of lines resolveAudio's --print template
+// produces per entry.
+const resolveFields = 5
+
+// resolveAudio runs a single yt-dlp call that prints the title, direct audio
+// URL, duration and (approximate) size of a video. yt-dlp is killed if ctx
+// is cancelled, e.g. when the client disconnects.
+func resolveAudio(ctx context.Context, youtubeURL string) (*resolvedAudio, error) {
+	return resolveFormat(ctx, youtubeURL, audioFormatSelector())
+}
+
+// resolveFormat is resolveAudio for any single-file format selector.
+func resolveFormat(ctx context.Context, youtubeURL, selector string) (*resolvedAudio, error) {
+	cmd := ytdlpCommandContext(ctx,
+		"--no-playlist",
+		// Channel and /@handle URLs can still expand to several entries
+		"--playlist-items", "1",
+		"-f", selector,
+		"--print", "%(title)s\n%(url)s\n%(duration)s\n%(filesize,filesize_approx)s\n%(ext)s",
+		"--",
+		youtubeURL,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := outputProcess(cmd)
+	if err != nil {
+		return nil, ytdlpFailure(err, stderr.String())
+	}
+	audio, err := parseResolveOutput(out)
+	if err != nil {
+		return nil, err
+	}
+	audio.Source = youtubeURL
+	return audio, nil
+}
+
+// parseResolveOutput reads resolveFormat's --print output: title, direct
+// URL, duration, size and ext, one per line.
+func parseResolveOutput(out []byte) (*resolvedAudio, error) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) > resolveFields {
+		// Should not happen with --playlist-items 1, but never mix fields
+		// from different videos: keep the first entry only
+		log.Printf("yt-dlp printed %d lines, using the first entry", len(lines))
+		lines = lines[:resolveFields]
+	}
+	audio := &resolvedAudio{Title: "YouTube Video"}
+	if len(lines) >= 1 && lines[0] != "" {
+		audio.Title = lines[0]
+	}
+	if len(lines) < 2 || strings.TrimSpace(lines[1]) == "" {
+		return nil, errors.New("no audio URL found")
+	}
+	audio.URL = strings.TrimSpace(lines[1])
+	if len(lines) >= 3 {
+		audio.Duration, _ = strconv.ParseFloat(strings.TrimSpace(lines[2]), 64)
+	}
+	if len(lines) >= 4 {
+		size, _ := strconv.ParseFloat(strings.TrimSpace(lines[3]), 64)
+		audio.Filesize = int64(size)
+	}
+	if len(lines) >= 5 && strings.TrimSpace(lines[4]) != "NA" {
+		audio.Ext = strings.TrimSpace(lines[4])
+	}
+	return audio, nil
+}
+
+var safeExtPattern = regexp.MustCompile(`^[a-z0-9]{1,5}$`)
+
+// outputExtension picks the file extension of what the client receives. All
+// download paths use it so the filename and X-Video-Extension match the body:
+// the conversion target if the output is converted, else yt-dlp's reported
+// ext when it is a safe [a-z0-9]{1,5} token (it ends up in a header), else a
+// guess from the upstream Content-Type.
+func outputExtension(convertTo, ytdlpExt, contentType string) string {
+	if convertTo != "" {
+		return convertTo
+	}
+	if ext := strings.ToLower(ytdlpExt); safeExtPattern.MatchString(ext) {
+		return ext
+	}
+	switch {
+	case strings.Contains(contentType, "webm") || strings.Contains(contentType, "ogg"):
+		return "webm"
+	case strings.HasPrefix(contentType, "video/mp4"):
+		return "mp4"
+	}
+	return "m4a"
+}
+
+// setDownloadHeaders names a download: Content-Disposition plus the
+// X-Video-Title and X-Video-Extension headers the web app reads.
+func setDownloadHeaders(h http.Header, title, ext string) {
+	name := title
+	if asciiFilenames {
+		name = asciiFilename(name)
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, sanitizeFilename(name), ext))
+	h.Set("X-Video-Title", title)
+	h.Set("X-Video-Extension", ext)
+}
+
+// formatSelectorPattern is what /audio accepts as a caller-supplied -f
+// selector: format ids, filters and fallbacks, but no spaces or quotes.
+var formatSelectorPattern = regexp.MustCompile(`^[0-9a-zA-Z._+/\[\]=-]+$`)
+
+// audioFormatSelector returns the yt-dlp -f selector for /audio. With an
+// extension allowlist configured, allowed containers are tried in order
+// before falling back to any best audio.
+func audioFormatSelector() string {
+	if len(allowedExts) == 0 {
+		return "bestaudio[ext=m4a]/bestaudio"
+	}
+	var alts []string
+	for _, ext := range allowedExts {
+		alts = append(alts, fmt.Sprintf("bestaudio[ext=%s]", ext))
+	}
+	return strings.Join(append(alts, "bestaudio"), "/")
+}
+
+// extAllowed reports whether ext may be returned by /audio.
+func extAllowed(ext string) bool {
+	if len(allowedExts) == 0 {
+		return true
+	}
+	for _, a := range allowedExts {
+		if a == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackConversion returns the first of TATATEXT_ALLOWED_EXTS that /audio
+// can convert to with this ffmpeg, for a source whose own extension isn't
+// allowed. "" means none can be reached.
+func fallbackConversion() string {
+	if !ffmpegAvailable() {
+		return ""
+	}
+	for _, ext := range allowedExts {
+		if conv, ok := audioConversions[ext]; ok && requireEncoder(conv.encoder) == nil {
+			return ext
+		}
+	}
+	return ""
+}
+
+// writeExtNotAllowed rejects a download whose extension is not in
+// TATATEXT_ALLOWED_EXTS.
+func writeExtNotAllowed(w http.ResponseWriter, ext string) {
+	writeJSONError(w, http.StatusUnsupportedMediaType, ErrUnsupportedExt,
+		fmt.Sprintf("%s is not an allowed output format (allowed: %s)", ext, strings.Join(allowedExts, ", ")))
+}
+
+// handleUpdate runs an update check on POST and installs any newer release.
+func handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "", "use POST to run an update")
+		return
+	}
+
+	if err := checkAndUpdate(true); err != nil {
+		writeJSONError(w, http.StatusBadGateway, "", "update failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "ytdlpVersion": ytdlp.Load().version})
+}
+
+// handleUpdatePause stops automatic updates until /update/resume, e.g.
+// while a broken yt-dlp release is out. Explicit POST /update still works.
+func handleUpdatePause(w http.ResponseWriter, r *http.Request) {
+	handleUpdatePaused(w, r, true)
+}
+
+// handleUpdateResume undoes /update/pause; a check skipped meanwhile runs now.
+func handleUpdateResume(w http.ResponseWriter, r *http.Request) {
+	handleUpdatePaused(w, r, false)
+}
+
+func handleUpdatePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "", "use POST")
+		return
+	}
+
+	setUpdatePaused(paused)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"updatePaused": paused})
+}
+
+// videoURLParam returns the url query parameter, undoing an extra layer of
+// percent-encoding if the client applied one.
+func videoURLParam(r *http.Request) string {
+	raw := r.URL.Query().Get("url")
+	if fixed, ok := fixDoubleEncodedURL(raw); ok {
+		log.Printf("corrected double-encoded url parameter: %s", fixed)
+		return fixed
+	}
+	return raw
+}
+
+// fixDoubleEncodedURL decodes raw once more if it looks double-encoded
+// ("https%3A%2F%2F..." or containing %25) and the result is a well-formed
+// http(s) URL. PathUnescape is used so a literal '+' survives.
+func fixDoubleEncodedURL(raw string) (string, bool) {
+	if !strings.Contains(raw, "%") {
+		return raw, false
+	}
+	if isWebURL(raw) && !strings.Contains(raw, "%25") {
+		return raw, false
+	}
+	decoded, err := url.PathUnescape(raw)
+	if err != nil || !isWebURL(decoded) {
+		return raw, false
+	}
+	return decoded, true
+}
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ytdlpCommand builds a yt-dlp invocation using the current binary, with the
+// options shared by every call placed before args.
+func ytdlpCommand(args ...string) *exec.Cmd {
+	return ytdlpCommandContext(context.Background(), args...)
+}
+
+// ytdlpCommandContext is like ytdlpCommand but kills yt-dlp when ctx is done.
+func ytdlpCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	bin := ytdlp.Load().path
+
+	common := []string{"--cache-dir", ytdlpCacheDir, "--user-agent", userAgent}
+	if sourceAddress != "" {
+		common = append(common, "--source-address", sourceAddress)
+	}
+	if cookiesFile != "" {
+		common = append(common, "--cookies", cookiesFile)
+	}
+	if cookiesBrowser != "" {
+		common = append(common, "--cookies-from-browser", cookiesBrowser)
+	}
+	return exec.CommandContext(ctx, bin, append(common, args...)...)
+}
+
+// sourceAddressTransport returns a proxy transport whose connections
+// originate from addr, which must be assigned to a local interface.
+func sourceAddressTransport(addr string) (http.RoundTripper, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", addr)
+	}
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	local := false
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			local = true
+			break
+		}
+	}
+	if !local {
+		return nil, fmt.Errorf("%s is not assigned to any local interface", addr)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: &net.TCPAddr{IP: ip},
+	}
+	t.DialContext = dialer.DialContext
+	return t, nil
+}
+
+func defaultCacheDir() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = os.TempDir()
 	}
+	return filepath.Join(configDir, CONFIG_DIR, "cache")
 }
 
 // extractYtDlp writes the embedded yt-dlp binary to a persistent config dir.
 // On next run it reuses the file unless it was replaced by auto-update.
 func extractYtDlp() string {
+	outPath := ytdlpInstallPath()
+	removeStaleTempFiles(filepath.Dir(outPath))
+	if pending := finishPendingYtDlp(outPath); pending != "" {
+		return pending
+	}
+
+	// Only extract if not already there (auto-update will overwrite)
+	if _, err := os.Stat(outPath); os.IsNotExist(err) {
+		if err := writeEmbeddedYtDlp(outPath); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("extracted embedded yt-dlp to %s", outPath)
+	}
+
+	return outPath
+}
+
+// pendingYtDlpFile records the versioned binary a fallback install put next
+// to a locked yt-dlp, so the next launch doesn't go back to the old one.
+func pendingYtDlpFile(outPath string) string {
+	return filepath.Join(filepath.Dir(outPath), "yt-dlp.pending")
+}
+
+// finishPendingYtDlp completes a fallback install from an earlier run by
+// moving the versioned binary over outPath, which nothing should be running
+// any more. If it is still locked, the versioned path is returned for use
+// instead; "" means outPath is current.
+func finishPendingYtDlp(outPath string) string {
+	pointer := pendingYtDlpFile(outPath)
+	b, err := os.ReadFile(pointer)
+	if err != nil {
+		return ""
+	}
+	versionedPath := strings.TrimSpace(string(b))
+	if _, err := os.Stat(versionedPath); err != nil {
+		os.Remove(pointer)
+		return ""
+	}
+	if err := renameWithRetry(versionedPath, outPath, policy.RenameAttempts); err != nil {
+		log.Printf("could not replace %s (%v); using %s", outPath, err, versionedPath)
+		return versionedPath
+	}
+	os.Remove(pointer)
+	log.Printf("moved %s into place at %s", versionedPath, outPath)
+	return ""
+}
+
+// removeStaleTempFiles deletes *.tmp files left in the config dir by an
+// update that was interrupted before its rename.
+func removeStaleTempFiles(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil {
+			log.Printf("could not remove stale %s: %v", m, err)
+		} else {
+			log.Printf("removed stale %s", m)
+		}
+	}
+}
+
+// ytdlpInstallPath returns where the yt-dlp binary lives in the config dir,
+// creating the directory if needed.
+func ytdlpInstallPath() string {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
 		configDir = os.TempDir()
@@ -157,25 +1186,29 @@ func extractYtDlp() string {
 	if runtime.GOOS == "windows" {
 		outName = "yt-dlp.exe"
 	}
-	outPath := filepath.Join(dir, outName)
+	return filepath.Join(dir, outName)
+}
 
-	// Only extract if not already there (auto-update will overwrite)
-	if _, err := os.Stat(outPath); os.IsNotExist(err) {
-		binName := "yt-dlp-mac"
-		if runtime.GOOS == "windows" {
-			binName = "yt-dlp-win.exe"
-		}
-		data, err := embeddedBinaries.ReadFile(binName)
-		if err != nil {
-			log.Fatalf("failed to read embedded %s: %v", binName, err)
-		}
-		if err := os.WriteFile(outPath, data, 0755); err != nil {
-			log.Fatal("failed to write yt-dlp:", err)
-		}
-		log.Printf("extracted embedded yt-dlp to %s", outPath)
+// writeEmbeddedYtDlp writes the yt-dlp binary bundled for this platform to
+// outPath, replacing whatever is there.
+func writeEmbeddedYtDlp(outPath string) error {
+	var binName string
+	switch runtime.GOOS {
+	case "windows":
+		binName = "yt-dlp-win.exe"
+	case "linux":
+		binName = "yt-dlp-linux"
+	default:
+		binName = "yt-dlp-mac"
 	}
-
-	return outPath
+	data, err := embeddedBinaries.ReadFile(binName)
+	if err != nil {
+		return fmt.Errorf("failed to read embedded %s: %w", binName, err)
+	}
+	if err := os.WriteFile(outPath, data, 0755); err != nil {
+		return fmt.Errorf("failed to write yt-dlp: %w", err)
+	}
+	return nil
 }
 
 func getYtDlpVersion(bin string) string {
@@ -187,52 +1220,274 @@ func getYtDlpVersion(bin string) string {
 }
 
 // autoUpdateYtDlp checks GitHub releases and downloads a newer yt-dlp if available.
-func autoUpdateYtDlp() {
-	// Check on startup, then every 6 hours
-	checkAndUpdate()
-	ticker := time.NewTicker(6 * time.Hour)
-	for range ticker.C {
-		checkAndUpdate()
+// In "manual" mode it does nothing; updates only happen via /update.
+func autoUpdateYtDlp(ctx context.Context) {
+	if systemYtDlp {
+		return
+	}
+	if updateMode == UPDATE_MANUAL {
+		log.Println("automatic update checks disabled (manual mode)")
+		return
+	}
+	apply := updateMode == UPDATE_AUTO
+
+	// Check on startup, then every UpdateInterval. While paused, due checks are
+	// skipped and one runs as soon as updates are resumed.
+	missed := true
+	ticker := time.NewTicker(policy.UpdateInterval)
+	defer ticker.Stop()
+	var retry <-chan time.Time // set while waiting out a GitHub rate limit
+	for {
+		updateMu.Lock()
+		paused := updatePaused
+		updateMu.Unlock()
+		if missed && !paused {
+			missed = false
+			var rl *githubRateLimitError
+			if err := checkAndUpdate(apply); errors.As(err, &rl) {
+				wait := time.Until(rl.reset) + time.Minute
+				log.Printf("GitHub API rate limited, retrying after reset at %s", rl.reset.Format(time.RFC3339))
+				retry = time.After(wait)
+			}
+		}
+
+		select {
+		case <-retry:
+			retry = nil
+			missed = true
+		case <-ticker.C:
+			missed = true
+			if paused {
+				log.Println("update check skipped, updates are paused")
+			}
+		case <-updateControl:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func checkAndUpdate() {
+// setUpdatePaused freezes or unfreezes automatic updates.
+func setUpdatePaused(paused bool) {
+	updateMu.Lock()
+	updatePaused = paused
+	updateMu.Unlock()
+	select {
+	case updateControl <- struct{}{}:
+	default: // a wake-up is already pending
+	}
+	if paused {
+		log.Println("automatic updates paused")
+	} else {
+		log.Println("automatic updates resumed")
+	}
+}
+
+// checkAndUpdate looks for a newer yt-dlp release. If apply is false a newer
+// release is only recorded and announced, not downloaded.
+func checkAndUpdate(apply bool) (err error) {
+	if systemYtDlp {
+		return errors.New("yt-dlp is managed externally (-ytdlp-path); update it with your package manager")
+	}
+	checkMu.Lock()
+	defer checkMu.Unlock()
+
+	// Surface the outcome in /ping
+	defer func() {
+		updateMu.Lock()
+		lastUpdateError = ""
+		if err != nil {
+			lastUpdateError = err.Error()
+		}
+		updateMu.Unlock()
+	}()
+
 	log.Println("checking for yt-dlp updates...")
-	latestVersion, downloadURL, err := getLatestYtDlpRelease()
+	latestVersion, downloadURL, err := getYtDlpRelease(pinnedVersion)
 	if err != nil {
-		log.Printf("update check failed: %v", err)
-		return
+		slog.Error("update check failed", "err", err)
+		return err
 	}
 
-	updateMu.Lock()
-	current := ytdlpVersion
-	updateMu.Unlock()
+	previous := ytdlp.Load()
+	current := previous.version
 
+	slog.Info("update check", "current", current, "latest", latestVersion, "apply", apply)
 	if current == latestVersion {
 		log.Printf("yt-dlp is up to date (%s)", current)
-		return
+		return nil
+	}
+	// Only move forwards, unless pinned: then the pin wins even if older.
+	// An unknown current version (broken binary) always updates.
+	if _, ok := parseYtDlpVersion(current); ok && pinnedVersion == "" {
+		if _, ok := parseYtDlpVersion(latestVersion); !ok {
+			log.Printf("skipping update: unrecognised release version %q", latestVersion)
+			return nil
+		}
+		if compareYtDlpVersions(latestVersion, current) <= 0 {
+			log.Printf("skipping update: release %s is not newer than %s", latestVersion, current)
+			return nil
+		}
+	}
+
+	if !apply {
+		updateMu.Lock()
+		announced := availableVersion == latestVersion
+		availableVersion = latestVersion
+		updateMu.Unlock()
+		log.Printf("yt-dlp %s is available (current %s); not applying in %s mode", latestVersion, current, updateMode)
+		if !announced {
+			showNotification("tatatext Helper", fmt.Sprintf("yt-dlp %s is available. Update it from tatatext.com when convenient.", latestVersion))
+		}
+		return nil
 	}
 
 	log.Printf("updating yt-dlp %s → %s", current, latestVersion)
-	newPath, err := downloadYtDlp(downloadURL)
+	newPath, err := downloadYtDlp(downloadURL, latestVersion)
 	if err != nil {
 		log.Printf("update download failed: %v", err)
-		return
+		return err
+	}
+
+	// Never point at a binary that doesn't run: go back to the previous one,
+	// and only if that fails too to the embedded copy
+	if getYtDlpVersion(newPath) == "unknown" {
+		log.Printf("yt-dlp %s at %s does not run, rolling back to %s", latestVersion, newPath, previous.version)
+		b, rerr := rollbackYtDlp(newPath, previous)
+		if rerr == nil {
+			ytdlp.Store(b)
+			return fmt.Errorf("yt-dlp %s does not run; kept %s", latestVersion, b.version)
+		}
+		log.Printf("rollback failed: %v; restoring the embedded binary", rerr)
+		b, rerr = restoreEmbeddedYtDlp()
+		if rerr != nil {
+			log.Printf("restoring embedded yt-dlp failed: %v", rerr)
+			return fmt.Errorf("yt-dlp %s does not run and the embedded binary could not be restored: %w", latestVersion, rerr)
+		}
+		ytdlp.Store(b)
+		updateMu.Lock()
+		ready = true
+		updateMu.Unlock()
+		log.Printf("recovered: now using embedded yt-dlp %s", b.version)
+		return fmt.Errorf("yt-dlp %s does not run; reverted to embedded %s", latestVersion, b.version)
 	}
 
+	ytdlp.Store(&ytdlpBinary{path: newPath, version: latestVersion})
 	updateMu.Lock()
-	ytdlpPath = newPath
-	ytdlpVersion = latestVersion
+	availableVersion = ""
+	ready = true
 	updateMu.Unlock()
 	log.Printf("yt-dlp updated to %s", latestVersion)
+	// A new release has to fetch the player JS again
+	go warmUpYtDlp()
+	return nil
+}
+
+// warmUpYtDlp runs a --simulate extraction of warmupVideo to populate
+// yt-dlp's player cache. It does nothing unless warm-up is enabled.
+func warmUpYtDlp() {
+	if warmupVideo == "" {
+		return
+	}
+	start := time.Now()
+	cmd := ytdlpCommand("--simulate", "--no-playlist", "--quiet", "--", warmupVideo)
+	if err := runProcess(cmd); err != nil {
+		log.Printf("yt-dlp warm-up failed after %s: %v", time.Since(start).Round(time.Millisecond), err)
+		return
+	}
+	log.Printf("yt-dlp warm-up finished in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// parseYtDlpVersion splits a yt-dlp version (YYYY.MM.DD or YYYY.MM.DD.N, as
+// used by nightly builds) into its numeric parts.
+func parseYtDlpVersion(v string) ([]int, bool) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(v), "v"), ".")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, false
+	}
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// compareYtDlpVersions returns -1, 0 or 1 as a is older than, equal to or
+// newer than b. A missing .N suffix sorts before any suffix on the same date.
+// Both versions must be parseable by parseYtDlpVersion.
+func compareYtDlpVersions(a, b string) int {
+	pa, _ := parseYtDlpVersion(a)
+	pb, _ := parseYtDlpVersion(b)
+	for i := 0; i < 4; i++ {
+		x, y := -1, -1
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// githubRateLimitError means the GitHub API quota is used up until reset.
+type githubRateLimitError struct {
+	reset time.Time
 }
 
-func getLatestYtDlpRelease() (version, downloadURL string, err error) {
-	resp, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", YTDLP_REPO))
+func (e *githubRateLimitError) Error() string {
+	return fmt.Sprintf("GitHub API rate limit exceeded until %s; set GITHUB_TOKEN for a higher limit", e.reset.Format(time.RFC3339))
+}
+
+// getYtDlpRelease looks up the release with the given tag, or the latest
+// release if tag is empty, and returns its version and the download URL of
+// the asset for this platform.
+func getYtDlpRelease(tag string) (version, downloadURL string, err error) {
+	endpoint := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", YTDLP_REPO)
+	if tag != "" {
+		endpoint = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", YTDLP_REPO, url.PathEscape(tag))
+	}
+	req, _ := http.NewRequest("GET", endpoint, nil)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	// Authenticated requests get 5000/hour instead of 60/hour per IP
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		// A badly wrong clock makes every certificate look expired or not yet valid
+		var certErr x509.CertificateInvalidError
+		if errors.As(err, &certErr) && certErr.Reason == x509.Expired {
+			log.Printf("TLS certificate validity check failed; the system clock may be incorrect (now %s)", time.Now().Format(time.RFC3339))
+			return "", "", fmt.Errorf("system clock may be incorrect, GitHub's certificate appears expired or not yet valid: %w", err)
+		}
 		return "", "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+			resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset := time.Now().Add(time.Hour)
+			if ts, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				reset = time.Unix(ts, 0)
+			}
+			return "", "", &githubRateLimitError{reset: reset}
+		}
+		if tag != "" && resp.StatusCode == http.StatusNotFound {
+			return "", "", fmt.Errorf("no yt-dlp release tagged %s", tag)
+		}
+		return "", "", fmt.Errorf("GitHub API: %s", resp.Status)
+	}
 
 	var release struct {
 		TagName string `json:"tag_name"`
@@ -245,22 +1500,22 @@ func getLatestYtDlpRelease() (version, downloadURL string, err error) {
 		return "", "", err
 	}
 
-	var assetName string
-	if runtime.GOOS == "windows" {
-		assetName = "yt-dlp.exe"
-	} else {
-		assetName = "yt-dlp_macos"
+	names := make([]string, len(release.Assets))
+	for i, asset := range release.Assets {
+		names[i] = asset.Name
 	}
-
-	for _, asset := range release.Assets {
-		if asset.Name == assetName {
-			return release.TagName, asset.BrowserDownloadURL, nil
-		}
+	i, pattern := matchYtDlpAsset(names, runtime.GOOS, runtime.GOARCH)
+	if i < 0 {
+		return "", "", fmt.Errorf("no yt-dlp asset for %s/%s in release %s", runtime.GOOS, runtime.GOARCH, release.TagName)
 	}
-	return "", "", fmt.Errorf("asset %s not found in release", assetName)
+	log.Printf("using release asset %s (matched %s)", names[i], pattern)
+	return release.TagName, release.Assets[i].BrowserDownloadURL, nil
 }
 
-func downloadYtDlp(url string) (string, error) {
+// downloadYtDlp fetches a release binary and moves it into place. If the
+// current binary can't be replaced (Windows locks it while an /audio request
+// is running it), the new one is kept under a versioned name instead.
+func downloadYtDlp(url, version string) (string, error) {
 	configDir, _ := os.UserConfigDir()
 	dir := filepath.Join(configDir, CONFIG_DIR)
 
@@ -271,53 +1526,238 @@ func downloadYtDlp(url string) (string, error) {
 	outPath := filepath.Join(dir, outName)
 	tmpPath := outPath + ".tmp"
 
+	expected, err := expectedYtDlpSHA256(url)
+	if err != nil {
+		return "", fmt.Errorf("fetching checksum: %w", err)
+	}
+
 	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
 
 	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
 	if err != nil {
 		return "", err
 	}
-	if _, err := io.Copy(f, resp.Body); err != nil {
+	// Gone already once renamed into place; otherwise don't leave it behind
+	defer os.Remove(tmpPath)
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
 		f.Close()
 		return "", err
 	}
 	f.Close()
+	if got := hex.EncodeToString(h.Sum(nil)); got != expected {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", path.Base(url), expected, got)
+	}
+
+	// Keep the current binary so a new one that doesn't run can be rolled back
+	if err := copyFile(outPath, outPath+".prev"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("could not back up %s: %v", outPath, err)
+	}
 
 	// Atomic replace
-	if err := os.Rename(tmpPath, outPath); err != nil {
+	err = renameWithRetry(tmpPath, outPath, policy.RenameAttempts)
+	if err == nil {
+		log.Printf("installed yt-dlp %s at %s", version, outPath)
+		os.Remove(pendingYtDlpFile(outPath))
+		removeVersionedYtDlp(dir, outPath)
+		return outPath, nil
+	}
+
+	// Never rename over a busy file: install alongside and repoint instead
+	versionedPath := filepath.Join(dir, strings.TrimSuffix(outName, ".exe")+"-"+version+filepath.Ext(outName))
+	log.Printf("could not replace %s (%v); installing yt-dlp %s as %s", outPath, err, version, versionedPath)
+	if err := rename(tmpPath, versionedPath); err != nil {
 		return "", err
 	}
-	return outPath, nil
+	if err := os.WriteFile(pendingYtDlpFile(outPath), []byte(versionedPath), 0644); err != nil {
+		log.Printf("could not record %s for the next launch: %v", versionedPath, err)
+	}
+	return versionedPath, nil
 }
 
+// expectedYtDlpSHA256 looks up an asset's hash in the SHA2-256SUMS file
+// published next to it in the same release.
+func expectedYtDlpSHA256(assetURL string) (string, error) {
+	u, err := url.Parse(assetURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	u.Path = path.Join(path.Dir(u.Path), "SHA2-256SUMS")
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("SHA2-256SUMS: %s", resp.Status)
+	}
+
+	// Lines are "<hex>  <name>"
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s in SHA2-256SUMS", name)
+}
+
+// copyFile copies src to dst with src's permissions, replacing dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// rollbackYtDlp undoes an update whose binary at newPath doesn't run. If it
+// replaced the install path, the .prev backup is moved back; otherwise the
+// new file is just removed, since previous was never replaced. Returns
+// previous once it is confirmed to run.
+func rollbackYtDlp(newPath string, previous *ytdlpBinary) (*ytdlpBinary, error) {
+	prevPath := newPath + ".prev"
+	if _, err := os.Stat(prevPath); err == nil {
+		if err := renameWithRetry(prevPath, newPath, policy.RenameAttempts); err != nil {
+			return nil, fmt.Errorf("restoring %s: %w", prevPath, err)
+		}
+	} else {
+		os.Remove(newPath)
+	}
+	if getYtDlpVersion(previous.path) == "unknown" {
+		return nil, fmt.Errorf("previous yt-dlp at %s does not run either", previous.path)
+	}
+	return previous, nil
+}
+
+// rename is os.Rename, replaceable so tests can simulate a locked target.
+var rename = os.Rename
+
+// renameWithRetry retries os.Rename a few times with increasing delays, for
+// targets that are briefly locked by a running process.
+func renameWithRetry(from, to string, attempts int) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = rename(from, to); err == nil {
+			return nil
+		}
+		if i < attempts-1 {
+			time.Sleep(time.Duration(i+1) * 200 * time.Millisecond)
+		}
+	}
+	return err
+}
+
+// removeVersionedYtDlp deletes versioned binaries left by earlier fallback
+// installs. Ones still in use fail to delete and are tried again next time.
+func removeVersionedYtDlp(dir, keep string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "yt-dlp-*"))
+	for _, m := range matches {
+		if m != keep && !strings.HasSuffix(m, ".tmp") {
+			os.Remove(m)
+		}
+	}
+}
+
+// asciiFold maps accented Latin letters to their plain ASCII form for
+// asciiFilename. Anything non-ASCII not listed here is dropped.
+var asciiFold = func() map[rune]string {
+	m := map[rune]string{
+		'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE",
+		'ø': "o", 'Ø': "O", 'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D", 'þ': "th", 'Þ': "Th",
+	}
+	for plain, accented := range map[string]string{
+		"a": "àáâãäåāăą", "A": "ÀÁÂÃÄÅĀĂĄ",
+		"c": "çćĉċč", "C": "ÇĆĈĊČ",
+		"e": "èéêëēĕėęě", "E": "ÈÉÊËĒĔĖĘĚ",
+		"g": "ĝğġģ", "G": "ĜĞĠĢ",
+		"i": "ìíîïĩīĭįı", "I": "ÌÍÎÏĨĪĬĮİ",
+		"n": "ñńņňŉ", "N": "ÑŃŅŇ",
+		"o": "òóôõöōŏő", "O": "ÒÓÔÕÖŌŎŐ",
+		"s": "śŝşš", "S": "ŚŜŞŠ",
+		"t": "ţťŧ", "T": "ŢŤŦ",
+		"u": "ùúûüũūŭůűų", "U": "ÙÚÛÜŨŪŬŮŰŲ",
+		"y": "ýÿŷ", "Y": "ÝŸŶ",
+		"z": "źżž", "Z": "ŹŻŽ",
+	} {
+		for _, r := range accented {
+			m[r] = plain
+		}
+	}
+	return m
+}()
+
+// asciiFilename reduces a title to ASCII for TATATEXT_ASCII_FILENAMES, for
+// Windows setups with non-Unicode locales that mangle other names. Accented
+// Latin letters are folded, other scripts dropped.
+func asciiFilename(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b.WriteRune(r)
+		case asciiFold[r] != "":
+			b.WriteString(asciiFold[r])
+		}
+	}
+	result := strings.Join(strings.Fields(b.String()), " ")
+	if result == "" {
+		return "download"
+	}
+	return result
+}
+
+// sanitizeFilename makes a title safe as a filename on every OS: characters
+// Windows forbids become "_" (one per run), it is cut to 80 runes so a
+// multi-byte character is never split, and leading or trailing dots and
+// spaces, which Windows strips or rejects, are removed.
 func sanitizeFilename(s string) string {
 	var b strings.Builder
+	replaced := false
 	for _, r := range s {
-		switch r {
-		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
-			b.WriteRune('_')
+		switch {
+		case r < 0x20, strings.ContainsRune(`/\:*?"<>|`, r):
+			if !replaced {
+				b.WriteRune('_')
+			}
+			replaced = true
 		default:
 			b.WriteRune(r)
+			replaced = false
 		}
 	}
-	result := b.String()
+	result := []rune(b.String())
 	if len(result) > 80 {
 		result = result[:80]
 	}
-	return result
-}
-
-func showNotification(title, message string) {
-	switch runtime.GOOS {
-	case "darwin":
-		script := fmt.Sprintf(`display notification "%s" with title "%s"`, message, title)
-		exec.Command("osascript", "-e", script).Run()
-	case "windows":
-		ps := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms; [System.Windows.Forms.MessageBox]::Show('%s','%s')`, message, title)
-		exec.Command("powershell", "-Command", ps).Run()
+	name := strings.Trim(string(result), ". ")
+	if name == "" {
+		return "download"
 	}
+	return name
 }
@@ -0,0 +1,827 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// newTestJob registers a /prepare job whose direct URL is audioURL, so
+// /audio can be exercised without running yt-dlp.
+func newTestJob(t *testing.T, audioURL string) string {
+	t.Helper()
+	token, err := addJob(&resolvedAudio{
+		Source: "https://www.youtube.com/watch?v=test",
+		Title:  "Test video",
+		URL:    audioURL,
+		Ext:    "m4a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAudioHashTrailer(t *testing.T) {
+	const payload = "hello"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mp4")
+		w.Header().Set("Content-Length", "5")
+		io.WriteString(w, payload)
+	}))
+	defer upstream.Close()
+	srv := httptest.NewServer(http.HandlerFunc(handleAudio))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/audio?hash=sha256&job=" + newTestJob(t, upstream.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != payload {
+		t.Fatalf("body = %q, want %q", body, payload)
+	}
+	sum := sha256.Sum256([]byte(payload))
+	if got, want := resp.Trailer.Get("X-Content-SHA256"), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("X-Content-SHA256 trailer = %q, want %q", got, want)
+	}
+}
+
+// setIdleTimeout shortens policy.DownloadIdleTimeout for one test.
+func setIdleTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	old := policy.DownloadIdleTimeout
+	policy.DownloadIdleTimeout = d
+	t.Cleanup(func() { policy.DownloadIdleTimeout = old })
+}
+
+func TestIdleTimeoutIgnoresSlowClient(t *testing.T) {
+	setIdleTimeout(t, 50*time.Millisecond)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mp4")
+		io.WriteString(w, "first second")
+	}))
+	defer upstream.Close()
+
+	resp, body, err := fetchAudio(context.Background(), upstream.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	first := make([]byte, 6)
+	if _, err := io.ReadFull(body, first); err != nil {
+		t.Fatal(err)
+	}
+	// The client stops reading for well over the idle timeout
+	time.Sleep(200 * time.Millisecond)
+	rest, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read after a slow client: %v", err)
+	}
+	if got := string(first) + string(rest); got != "first second" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestIdleTimeoutCancelsStalledUpstream(t *testing.T) {
+	setIdleTimeout(t, 50*time.Millisecond)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mp4")
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	resp, body, err := fetchAudio(context.Background(), upstream.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, err = io.ReadAll(body)
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Fatalf("err = %v, want an idle timeout", err)
+	}
+}
+
+func TestParseYtDlpVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"2024.08.06", []int{2024, 8, 6}, true},
+		{"2024.08.06.1", []int{2024, 8, 6, 1}, true},
+		{" v2024.08.06\n", []int{2024, 8, 6}, true},
+		{"unknown", nil, false},
+		{"", nil, false},
+		{"2024.08", nil, false},
+		{"2024.08.06.1.2", nil, false},
+		{"2024.08.x", nil, false},
+		{"2024.-1.06", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseYtDlpVersion(tt.in)
+		if ok != tt.ok || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseYtDlpVersion(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompareYtDlpVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2024.08.06", "2024.08.06", 0},
+		{"2024.08.06.1", "2024.08.06.1", 0},
+		{"2024.08.06", "2024.08.06.1", -1},
+		{"2024.08.06.1", "2024.08.06", 1},
+		{"2024.08.06.2", "2024.08.06.10", -1},
+		{"2024.07.30", "2024.08.06", -1},
+		{"2024.08.06", "2023.12.31", 1},
+		{"2024.12.01", "2024.08.06.5", 1},
+		{"v2024.08.06", "2024.08.06", 0},
+	}
+	for _, tt := range tests {
+		if got := compareYtDlpVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareYtDlpVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// lockedRename makes every rename onto target fail the way Windows does
+// while yt-dlp is running, and counts the attempts.
+func lockedRename(t *testing.T, target string) (attempts *int) {
+	t.Helper()
+	attempts = new(int)
+	prev := rename
+	rename = func(from, to string) error {
+		if to == target {
+			*attempts++
+			return &os.LinkError{Op: "rename", Old: from, New: to, Err: errors.New("Access is denied.")}
+		}
+		return os.Rename(from, to)
+	}
+	t.Cleanup(func() { rename = prev })
+	return attempts
+}
+
+func TestDownloadYtDlpLockedBinary(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	old := policy.RenameAttempts
+	policy.RenameAttempts = 3
+	t.Cleanup(func() { policy.RenameAttempts = old })
+
+	release := []byte("#!/bin/sh\necho 2024.09.01\n")
+	sum := sha256.Sum256(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dl/yt-dlp":
+			w.Write(release)
+		case "/dl/SHA2-256SUMS":
+			fmt.Fprintf(w, "%x  yt-dlp\n", sum)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	outPath := ytdlpInstallPath()
+	if err := os.WriteFile(outPath, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	attempts := lockedRename(t, outPath)
+
+	got, err := downloadYtDlp(srv.URL+"/dl/yt-dlp", "2024.09.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versioned := filepath.Join(filepath.Dir(outPath), "yt-dlp-2024.09.01")
+	if got != versioned {
+		t.Errorf("installed at %s, want the versioned %s", got, versioned)
+	}
+	if *attempts != 3 {
+		t.Errorf("tried the rename %d times, want 3", *attempts)
+	}
+	if b, _ := os.ReadFile(versioned); string(b) != string(release) {
+		t.Errorf("versioned binary = %q", b)
+	}
+
+	// Still locked on the next launch: keep using the versioned binary
+	if got := extractYtDlp(); got != versioned {
+		t.Errorf("next launch uses %s, want %s", got, versioned)
+	}
+
+	// Unlocked: the versioned binary is moved into place
+	rename = os.Rename
+	if got := extractYtDlp(); got != outPath {
+		t.Errorf("next launch uses %s, want %s", got, outPath)
+	}
+	if b, _ := os.ReadFile(outPath); string(b) != string(release) {
+		t.Errorf("%s = %q after finishing the install", outPath, b)
+	}
+	if _, err := os.Stat(pendingYtDlpFile(outPath)); !os.IsNotExist(err) {
+		t.Errorf("pending file still there: %v", err)
+	}
+}
+
+func TestRenameWithRetryNoSleepAfterLastAttempt(t *testing.T) {
+	dir := t.TempDir()
+	to := filepath.Join(dir, "to")
+	attempts := lockedRename(t, to)
+	start := time.Now()
+	if err := renameWithRetry(filepath.Join(dir, "from"), to, 1); err == nil {
+		t.Fatal("rename onto a locked target succeeded")
+	}
+	if *attempts != 1 {
+		t.Errorf("attempts = %d, want 1", *attempts)
+	}
+	if d := time.Since(start); d >= 200*time.Millisecond {
+		t.Errorf("a single failed attempt took %v; it should not sleep", d)
+	}
+}
+
+// An upstream that breaks off mid-stream must not reach the client as a
+// clean, complete download, whether or not it sent a Content-Length.
+func TestAudioTruncatedUpstream(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{
+		"short Content-Length": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "audio/mp4")
+			w.Header().Set("Content-Length", "100")
+			io.WriteString(w, "only ten b")
+		},
+		"chunked stream cut off": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "audio/mp4")
+			io.WriteString(w, "only ten b")
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			upstream := httptest.NewServer(h)
+			defer upstream.Close()
+			fakeYtDlp(t, resolveOutput(upstream.URL))
+			srv := httptest.NewServer(http.HandlerFunc(handleAudio))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/audio?url=" + url.QueryEscape("https://www.youtube.com/watch?v=test"))
+			if err != nil {
+				return // the connection was reset before the headers
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err == nil {
+				t.Fatalf("read %d bytes with status %d and no error; want a reset or unexpected EOF", len(body), resp.StatusCode)
+			}
+		})
+	}
+}
+
+// fakeYtDlp installs a shell script as the yt-dlp binary. Each run prints
+// the next of outputs (the last one repeats) and is counted in the returned
+// func.
+func fakeYtDlp(t *testing.T, outputs ...string) (runs func() int) {
+	t.Helper()
+	dir := t.TempDir()
+	for i, out := range outputs {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("out%d", i)), []byte(out), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+dir=%q
+n=$(cat "$dir/runs" 2>/dev/null || echo 0)
+echo $((n + 1)) > "$dir/runs"
+[ "$n" -ge %d ] && n=%d
+cat "$dir/out$n"
+`, dir, len(outputs)-1, len(outputs)-1)
+	bin := filepath.Join(dir, "yt-dlp")
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	prev := ytdlp.Load()
+	ytdlp.Store(&ytdlpBinary{path: bin, version: "2024.08.06"})
+	t.Cleanup(func() { ytdlp.Store(prev) })
+	return func() int {
+		b, _ := os.ReadFile(filepath.Join(dir, "runs"))
+		n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		return n
+	}
+}
+
+// resolveOutput is what resolveFormat's --print template produces.
+func resolveOutput(directURL string) string {
+	return "Test video\n" + directURL + "\n60\n5\nm4a\n"
+}
+
+func TestFetchAudioRejectsHTML(t *testing.T) {
+	for _, ct := range []string{"text/html; charset=utf-8", "application/octet-stream"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", ct)
+			io.WriteString(w, "<!DOCTYPE html><html><body>Sign in to confirm</body></html>")
+		}))
+		_, _, err := fetchAudio(context.Background(), upstream.URL, "")
+		upstream.Close()
+		if !errors.Is(err, errUpstreamNotMedia) {
+			t.Errorf("Content-Type %s: err = %v, want errUpstreamNotMedia", ct, err)
+		}
+	}
+}
+
+func TestAudioReresolvesOnceAfterHTML(t *testing.T) {
+	html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html><body>blocked</body></html>")
+	}))
+	defer html.Close()
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mp4")
+		io.WriteString(w, "audio")
+	}))
+	defer media.Close()
+	srv := httptest.NewServer(http.HandlerFunc(handleAudio))
+	defer srv.Close()
+	get := func() (*http.Response, string) {
+		resp, err := http.Get(srv.URL + "/audio?url=" + url.QueryEscape("https://www.youtube.com/watch?v=test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	t.Run("fresh URL works", func(t *testing.T) {
+		runs := fakeYtDlp(t, resolveOutput(html.URL), resolveOutput(media.URL))
+		resp, body := get()
+		if resp.StatusCode != http.StatusOK || body != "audio" {
+			t.Errorf("got %d %q, want 200 \"audio\"", resp.StatusCode, body)
+		}
+		if runs() != 2 {
+			t.Errorf("yt-dlp ran %d times, want 2", runs())
+		}
+	})
+	t.Run("fresh URL is HTML too", func(t *testing.T) {
+		runs := fakeYtDlp(t, resolveOutput(html.URL))
+		resp, _ := get()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", resp.StatusCode)
+		}
+		if runs() != 2 {
+			t.Errorf("yt-dlp ran %d times, want 2 (a single re-resolve)", runs())
+		}
+	})
+}
+
+// /check must not leave yt-dlp running, holding an extraction slot, after
+// the client goes away.
+func TestCheckStopsYtDlpOnDisconnect(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "yt-dlp")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	prev := ytdlp.Load()
+	ytdlp.Store(&ytdlpBinary{path: bin, version: "2024.08.06"})
+	t.Cleanup(func() { ytdlp.Store(prev) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/check?url="+url.QueryEscape("https://www.youtube.com/watch?v=test"), nil)
+	start := time.Now()
+	handleCheck(httptest.NewRecorder(), req.WithContext(ctx))
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("/check took %v after the client left", d)
+	}
+	if n := len(extractSlots); n != 0 {
+		t.Errorf("%d extraction slots still held", n)
+	}
+}
+
+func TestFixDoubleEncodedURL(t *testing.T) {
+	tests := []struct {
+		name, raw, want string
+		fixed           bool
+	}{
+		{"plain URL", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"single-encoded query left alone", "https://www.youtube.com/watch?v=abc&list=PL%2Dx", "https://www.youtube.com/watch?v=abc&list=PL%2Dx", false},
+		{"double-encoded", "https%3A%2F%2Fwww.youtube.com%2Fwatch%3Fv%3DdQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", true},
+		{"%25 inside a valid URL", "https://www.youtube.com/watch?v=abc%26t%3D42", "https://www.youtube.com/watch?v=abc%26t%3D42", false},
+		{"%25 escapes decoded once", "https://www.youtube.com/watch?v=abc%2526t%253D42", "https://www.youtube.com/watch?v=abc%26t%3D42", true},
+		{"literal + survives", "https%3A%2F%2Fwww.youtube.com%2Fresults%3Fsearch_query%3Da+b", "https://www.youtube.com/results?search_query=a+b", true},
+		{"not a URL after decoding", "abc%20def", "abc%20def", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fixed := fixDoubleEncodedURL(tt.raw)
+			if got != tt.want || fixed != tt.fixed {
+				t.Errorf("fixDoubleEncodedURL(%q) = %q, %v; want %q, %v", tt.raw, got, fixed, tt.want, tt.fixed)
+			}
+		})
+	}
+}
+
+// Run with -race: swapping the binary during an update must not race with
+// requests building yt-dlp commands.
+func TestYtDlpBinarySwapIsRaceFree(t *testing.T) {
+	prev := ytdlp.Load()
+	t.Cleanup(func() { ytdlp.Store(prev) })
+	ytdlp.Store(&ytdlpBinary{path: "/old/yt-dlp", version: "2024.07.30"})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				bin := ytdlp.Load()
+				if bin.path == "" || bin.version == "" {
+					t.Error("read a half-initialised binary")
+					return
+				}
+				ytdlpCommand("--version")
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		ytdlp.Store(&ytdlpBinary{path: fmt.Sprintf("/new/yt-dlp-%d", i), version: "2024.08.06"})
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestOutputExtension(t *testing.T) {
+	tests := []struct {
+		name, convertTo, ytdlpExt, contentType, want string
+	}{
+		{"m4a", "", "m4a", "audio/mp4", "m4a"},
+		{"mka", "", "mka", "audio/x-matroska", "mka"},
+		{"3gp", "", "3gp", "video/3gpp", "3gp"},
+		{"upper case", "", "WEBM", "audio/webm", "webm"},
+		{"empty ext, webm", "", "", "audio/webm", "webm"},
+		{"empty ext, mp4 video", "", "", "video/mp4", "mp4"},
+		{"empty ext, unknown type", "", "", "", "m4a"},
+		{"header injection", "", "m4a\"\r\nSet-Cookie: x=1", "audio/mp4", "m4a"},
+		{"quote and CRLF", "", `m4a"` + "\r\n", "audio/webm", "webm"},
+		{"too long", "", "abcdef", "audio/mp4", "m4a"},
+		{"converted", "mp3", "webm", "audio/webm", "mp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outputExtension(tt.convertTo, tt.ytdlpExt, tt.contentType); got != tt.want {
+				t.Errorf("outputExtension(%q, %q, %q) = %q, want %q", tt.convertTo, tt.ytdlpExt, tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResolveOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want resolvedAudio
+		err  bool
+	}{
+		{
+			name: "single entry",
+			out:  "First\nhttps://a.example/1\n212.5\n3400000\nm4a\n",
+			want: resolvedAudio{Title: "First", URL: "https://a.example/1", Duration: 212.5, Filesize: 3400000, Ext: "m4a"},
+		},
+		{
+			name: "multi-entry output keeps the first entry only",
+			out: "First\nhttps://a.example/1\n212\n3400000\nm4a\n" +
+				"Second\nhttps://a.example/2\n99\n1000\nwebm\n" +
+				"Third\nhttps://a.example/3\n5\n10\nopus\n",
+			want: resolvedAudio{Title: "First", URL: "https://a.example/1", Duration: 212, Filesize: 3400000, Ext: "m4a"},
+		},
+		{
+			name: "NA fields",
+			out:  "Live\nhttps://a.example/1\nNA\nNA\nNA\n",
+			want: resolvedAudio{Title: "Live", URL: "https://a.example/1"},
+		},
+		{
+			name: "approximate size",
+			out:  "Approx\nhttps://a.example/1\n60\n1234567.8\nwebm\n",
+			want: resolvedAudio{Title: "Approx", URL: "https://a.example/1", Duration: 60, Filesize: 1234567, Ext: "webm"},
+		},
+		{name: "no URL", out: "Only a title\n", err: true},
+		{name: "empty", out: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResolveOutput([]byte(tt.out))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// Magic bytes that http.DetectContentType recognises for each output format.
+var (
+	sampleMP3  = append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), make([]byte, 64)...)
+	sampleWebM = append([]byte{0x1A, 0x45, 0xDF, 0xA3}, make([]byte, 64)...)
+	sampleMP4  = append([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), make([]byte, 64)...)
+)
+
+// sniffedExt maps a sniffed Content-Type to the extension a file with that
+// body must have.
+var sniffedExt = map[string]string{
+	"audio/mpeg": "mp3",
+	"video/webm": "webm",
+	"video/mp4":  "mp4",
+}
+
+// fakeYtDlpDownload installs a yt-dlp script that "downloads" content as
+// <-o template with ext> and prints the title and path like --print
+// after_move does.
+func fakeYtDlpDownload(t *testing.T, ext string, content []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "content")
+	if err := os.WriteFile(src, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+while [ $# -gt 0 ]; do
+	[ "$1" = "-o" ] && out="$2"
+	shift
+done
+path=$(echo "$out" | sed 's/%%(ext)s/%s/')
+cp %q "$path"
+echo "Test video"
+echo "$path"
+`, ext, src)
+	bin := filepath.Join(dir, "yt-dlp")
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	prev := ytdlp.Load()
+	ytdlp.Store(&ytdlpBinary{path: bin, version: "2024.08.06"})
+	t.Cleanup(func() { ytdlp.Store(prev) })
+}
+
+// fakeFFmpeg installs an ffmpeg script with libmp3lame that turns any input
+// into sampleMP3.
+func fakeFFmpeg(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "out.mp3")
+	if err := os.WriteFile(src, sampleMP3, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+for a in "$@"; do
+	if [ "$a" = "-encoders" ]; then
+		printf ' ------\n A....D libmp3lame           libmp3lame MP3\n'
+		exit 0
+	fi
+done
+cat >/dev/null
+cat %q
+`, src)
+	bin := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	prevPath := ffmpegPath
+	ffmpegPath = bin
+	encodersOnce, audioEncoders = sync.Once{}, nil
+	t.Cleanup(func() {
+		ffmpegPath = prevPath
+		encodersOnce, audioEncoders = sync.Once{}, nil
+	})
+}
+
+// Every download path must name the file after what it actually sends.
+func TestDownloadHeadersMatchBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/webm")
+		w.Write(sampleWebM)
+	}))
+	defer upstream.Close()
+	token, err := addJob(&resolvedAudio{
+		Source: "https://www.youtube.com/watch?v=test",
+		Title:  "Test video",
+		URL:    upstream.URL,
+		Ext:    "webm",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoURL := url.QueryEscape("https://www.youtube.com/watch?v=test")
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T)
+		handler http.HandlerFunc
+		query   string
+	}{
+		{"passthrough", nil, handleAudio, "job=" + token},
+		{"convert=mp3", fakeFFmpeg, handleAudio, "convert=mp3&job=" + token},
+		{"video merge", func(t *testing.T) {
+			fakeFFmpeg(t)
+			fakeYtDlpDownload(t, "mp4", sampleMP4)
+		}, handleVideo, "url=" + videoURL},
+		{"section", func(t *testing.T) {
+			fakeFFmpeg(t)
+			fakeYtDlpDownload(t, "webm", sampleWebM)
+		}, handleAudio, "start=10&end=20&url=" + videoURL},
+		{"section with convert=mp3", func(t *testing.T) {
+			fakeFFmpeg(t)
+			fakeYtDlpDownload(t, "mp3", sampleMP3)
+		}, handleAudio, "start=10&end=20&convert=mp3&url=" + videoURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			sniffed := http.DetectContentType(rec.Body.Bytes())
+			ext := rec.Header().Get("X-Video-Extension")
+			if want := sniffedExt[sniffed]; ext != want {
+				t.Errorf("X-Video-Extension = %q for a %s body, want %q", ext, sniffed, want)
+			}
+			if cd := rec.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, `.`+ext+`"`) {
+				t.Errorf("Content-Disposition %q does not end in .%s", cd, ext)
+			}
+		})
+	}
+}
+
+// With every process slot taken, convert= must fail with a JSON error
+// before the download starts, not reset a response already under way.
+func TestAudioConvertWithoutProcessSlot(t *testing.T) {
+	fakeFFmpeg(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mp4")
+		w.Write(sampleWebM)
+	}))
+	defer upstream.Close()
+	limit := procs.limit
+	procs.limit = 0
+	t.Cleanup(func() { procs.limit = limit })
+
+	rec := httptest.NewRecorder()
+	handleAudio(rec, httptest.NewRequest(http.MethodGet, "/audio?convert=mp3&job="+newTestJob(t, upstream.URL), nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want a JSON error", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("download headers sent with the error: %q", cd)
+	}
+}
+
+// A source in a disallowed format is converted to the first allowed one
+// ffmpeg can produce, and only rejected when there is none.
+func TestAudioConvertsToAllowedExt(t *testing.T) {
+	prev := allowedExts
+	allowedExts = []string{"mp3"}
+	t.Cleanup(func() { allowedExts = prev })
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/webm")
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-9/%d", len(sampleWebM)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(sampleWebM[:10])
+			return
+		}
+		w.Write(sampleWebM)
+	}))
+	defer upstream.Close()
+	token := newTestJob(t, upstream.URL)
+
+	t.Run("with ffmpeg", func(t *testing.T) {
+		fakeFFmpeg(t)
+		for _, rangeHeader := range []string{"", "bytes=0-9"} {
+			req := httptest.NewRequest(http.MethodGet, "/audio?job="+token, nil)
+			if rangeHeader != "" {
+				req.Header.Set("Range", rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			handleAudio(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Range %q: status %d: %s", rangeHeader, rec.Code, rec.Body)
+			}
+			if ext := rec.Header().Get("X-Video-Extension"); ext != "mp3" {
+				t.Errorf("Range %q: X-Video-Extension = %q, want mp3", rangeHeader, ext)
+			}
+			if got := rec.Body.String(); got != string(sampleMP3) {
+				t.Errorf("Range %q: body is not the converted file", rangeHeader)
+			}
+		}
+	})
+	t.Run("without ffmpeg", func(t *testing.T) {
+		prevPath := ffmpegPath
+		ffmpegPath = ""
+		t.Cleanup(func() { ffmpegPath = prevPath })
+		rec := httptest.NewRecorder()
+		handleAudio(rec, httptest.NewRequest(http.MethodGet, "/audio?job="+token, nil))
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("status %d, want 415", rec.Code)
+		}
+	})
+}
+
+func TestASCIIFilename(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Café Müller – Straße", "Cafe Muller Strasse"},
+		{"Łódź in 4K", "Lodz in 4K"},
+		{"Москва: Red Square (4K)", ": Red Square (4K)"},
+		{"Интервью с Иваном", "download"},
+		{"日本語のタイトル", "download"},
+		{"东京 Tokyo 2024", "Tokyo 2024"},
+		{"🎵🎶", "download"},
+		{"", "download"},
+	}
+	for _, tt := range tests {
+		got := asciiFilename(tt.in)
+		if got != tt.want {
+			t.Errorf("asciiFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		for _, r := range got {
+			if r >= 0x80 {
+				t.Errorf("asciiFilename(%q) = %q contains non-ASCII %q", tt.in, got, r)
+				break
+			}
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	longCJK := strings.Repeat("日本語のタイトル", 15) // 120 runes
+	tests := []struct {
+		name, in, want string
+	}{
+		{"CJK longer than 80 runes", longCJK, string([]rune(longCJK)[:80])},
+		{"trailing period", "Ends with a period.", "Ends with a period"},
+		{"leading and trailing dots and spaces", " ..hidden name.. ", "hidden name"},
+		{"forbidden characters", `a/b\c:d`, "a_b_c_d"},
+		{"runs collapse", `What?!? "Quoted" <a|b>`, "What_!_ _Quoted_ _a_b_"},
+		{"run of forbidden characters", `AC/DC :: **Live**`, "AC_DC _ _Live_"},
+		{"control characters", "tab\there\nnewline", "tab_here_newline"},
+		{"nothing left", "...", "download"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeFilename(tt.in)
+			if got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("sanitizeFilename(%q) = %q is not valid UTF-8", tt.in, got)
+			}
+			if n := utf8.RuneCountInString(got); n > 80 {
+				t.Errorf("sanitizeFilename(%q) has %d runes, want at most 80", tt.in, n)
+			}
+		})
+	}
+}
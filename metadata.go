@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// videoMetadata is what /metadata returns for a preview card.
+type videoMetadata struct {
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	Duration  float64 `json:"duration,omitempty"`
+	Uploader  string  `json:"uploader,omitempty"`
+	Thumbnail string  `json:"thumbnail,omitempty"`
+}
+
+// handleMetadata returns a video's title, duration, uploader and thumbnail
+// without downloading anything, so the web app can preview it first.
+func handleMetadata(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := ytdlpCommandContext(r.Context(),
+		"--simulate",
+		"--no-playlist",
+		"--playlist-items", "1",
+		"--print", "%(title)s\n%(duration)s\n%(uploader)s\n%(thumbnail)s\n%(id)s",
+		"--",
+		youtubeURL,
+	)
+	cmd.Stderr = &stderr
+	out, err := outputProcess(cmd)
+	if err != nil {
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	field := func(i int) string {
+		if i < len(lines) && strings.TrimSpace(lines[i]) != "NA" {
+			return strings.TrimSpace(lines[i])
+		}
+		return ""
+	}
+	meta := videoMetadata{
+		Title:     field(0),
+		Uploader:  field(2),
+		Thumbnail: field(3),
+		ID:        field(4),
+	}
+	meta.Duration, _ = strconv.ParseFloat(field(1), 64)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Notifier delivers user-facing notifications.
+type Notifier interface {
+	Notify(title, message string) error
+}
+
+// notifier is selected at startup by TATATEXT_NOTIFY.
+var notifier Notifier = noopNotifier{}
+
+// newNotifierFromEnv picks a notifier from TATATEXT_NOTIFY:
+//
+//	(unset)      the desktop notifier for this OS
+//	macos        osascript notification
+//	windows      Windows toast via PowerShell
+//	linux        notify-send
+//	webhook      POST JSON to TATATEXT_NOTIFY_WEBHOOK (e.g. an ntfy topic)
+//	none         no notifications, for headless servers
+func newNotifierFromEnv() Notifier {
+	switch kind := os.Getenv("TATATEXT_NOTIFY"); kind {
+	case "":
+		return desktopNotifier()
+	case "macos":
+		return osascriptNotifier{}
+	case "windows":
+		return toastNotifier{}
+	case "linux":
+		return notifySendNotifier{}
+	case "webhook":
+		url := os.Getenv("TATATEXT_NOTIFY_WEBHOOK")
+		if url == "" {
+			log.Println("TATATEXT_NOTIFY=webhook needs TATATEXT_NOTIFY_WEBHOOK; notifications disabled")
+			return noopNotifier{}
+		}
+		return webhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+	case "none":
+		return noopNotifier{}
+	default:
+		log.Printf("unknown TATATEXT_NOTIFY %q, using the desktop default", kind)
+		return desktopNotifier()
+	}
+}
+
+func desktopNotifier() Notifier {
+	switch runtime.GOOS {
+	case "darwin":
+		return osascriptNotifier{}
+	case "windows":
+		return toastNotifier{}
+	case "linux":
+		return notifySendNotifier{}
+	}
+	return noopNotifier{}
+}
+
+// showNotification sends a notification through the configured notifier.
+// Failures are logged, never fatal.
+func showNotification(title, message string) {
+	if err := notifier.Notify(title, message); err != nil {
+		log.Printf("notification failed: %v", err)
+	}
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(title, message string) error { return nil }
+
+type osascriptNotifier struct{}
+
+func (osascriptNotifier) Notify(title, message string) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	script := fmt.Sprintf(`display notification "%s" with title "%s"`, quote.Replace(message), quote.Replace(title))
+	return exec.Command("osascript", "-e", script).Run()
+}
+
+type toastNotifier struct{}
+
+// powershellAppID is PowerShell's own AppUserModelID; toasts from an
+// unregistered ID are silently dropped.
+const powershellAppID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+func (toastNotifier) Notify(title, message string) error {
+	quote := strings.NewReplacer(`'`, `''`)
+	ps := fmt.Sprintf(`[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$x = $t.GetElementsByTagName('text')
+$x.Item(0).AppendChild($t.CreateTextNode('%s')) > $null
+$x.Item(1).AppendChild($t.CreateTextNode('%s')) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('%s').Show([Windows.UI.Notifications.ToastNotification]::new($t))`,
+		quote.Replace(title), quote.Replace(message), powershellAppID)
+	return exec.Command("powershell", "-NoProfile", "-Command", ps).Run()
+}
+
+type notifySendNotifier struct{}
+
+func (notifySendNotifier) Notify(title, message string) error {
+	return exec.Command("notify-send", "--app-name=tatatext Helper", title, message).Run()
+}
+
+// webhookNotifier POSTs {"title", "message"} as JSON to a URL.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n webhookNotifier) Notify(title, message string) error {
+	body, _ := json.Marshal(map[string]string{"title": title, "message": message})
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
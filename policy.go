@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Policy collects the helper's timeouts, intervals and retry counts in one
+// place, so the effective values can be logged and reasoned about together.
+type Policy struct {
+	DownloadIdleTimeout time.Duration // TATATEXT_DOWNLOAD_IDLE_TIMEOUT: cancel an upstream download after this long without data
+	ShutdownGrace       time.Duration // TATATEXT_SHUTDOWN_GRACE: how long active downloads get after SIGINT/SIGTERM
+	UpdateInterval      time.Duration // TATATEXT_UPDATE_INTERVAL: between automatic update checks
+	SelfCheckInterval   time.Duration // TATATEXT_SELF_CHECK_INTERVAL: between checks that yt-dlp still runs
+	RenameAttempts      int           // TATATEXT_RENAME_ATTEMPTS: tries at replacing a possibly locked binary
+}
+
+func defaultPolicy() Policy {
+	return Policy{
+		DownloadIdleTimeout: 60 * time.Second,
+		ShutdownGrace:       30 * time.Second,
+		UpdateInterval:      6 * time.Hour,
+		SelfCheckInterval:   time.Hour,
+		RenameAttempts:      5,
+	}
+}
+
+var policy = defaultPolicy()
+
+// loadPolicy applies TATATEXT_ env overrides to the defaults. Invalid values
+// are logged and ignored.
+func loadPolicy() Policy {
+	p := defaultPolicy()
+	for name, dst := range map[string]*time.Duration{
+		"TATATEXT_DOWNLOAD_IDLE_TIMEOUT": &p.DownloadIdleTimeout,
+		"TATATEXT_SHUTDOWN_GRACE":        &p.ShutdownGrace,
+		"TATATEXT_UPDATE_INTERVAL":       &p.UpdateInterval,
+		"TATATEXT_SELF_CHECK_INTERVAL":   &p.SelfCheckInterval,
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			*dst = d
+		} else {
+			log.Printf("ignoring invalid %s %q", name, v)
+		}
+	}
+	if v := os.Getenv("TATATEXT_RENAME_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			p.RenameAttempts = n
+		} else {
+			log.Printf("ignoring invalid TATATEXT_RENAME_ATTEMPTS %q", v)
+		}
+	}
+	return p
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		if got := loadPolicy(); got != defaultPolicy() {
+			t.Errorf("got %+v, want the defaults %+v", got, defaultPolicy())
+		}
+	})
+	t.Run("overrides", func(t *testing.T) {
+		t.Setenv("TATATEXT_DOWNLOAD_IDLE_TIMEOUT", "2m")
+		t.Setenv("TATATEXT_SHUTDOWN_GRACE", "5s")
+		t.Setenv("TATATEXT_UPDATE_INTERVAL", "24h")
+		t.Setenv("TATATEXT_SELF_CHECK_INTERVAL", "15m")
+		t.Setenv("TATATEXT_RENAME_ATTEMPTS", "9")
+		want := Policy{
+			DownloadIdleTimeout: 2 * time.Minute,
+			ShutdownGrace:       5 * time.Second,
+			UpdateInterval:      24 * time.Hour,
+			SelfCheckInterval:   15 * time.Minute,
+			RenameAttempts:      9,
+		}
+		if got := loadPolicy(); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+	t.Run("invalid values are ignored", func(t *testing.T) {
+		t.Setenv("TATATEXT_DOWNLOAD_IDLE_TIMEOUT", "sixty")
+		t.Setenv("TATATEXT_SHUTDOWN_GRACE", "-5s")
+		t.Setenv("TATATEXT_UPDATE_INTERVAL", "0s")
+		t.Setenv("TATATEXT_SELF_CHECK_INTERVAL", "10") // no unit
+		t.Setenv("TATATEXT_RENAME_ATTEMPTS", "0")
+		if got := loadPolicy(); got != defaultPolicy() {
+			t.Errorf("got %+v, want the defaults %+v", got, defaultPolicy())
+		}
+	})
+	t.Run("one invalid value leaves the others", func(t *testing.T) {
+		t.Setenv("TATATEXT_SHUTDOWN_GRACE", "10s")
+		t.Setenv("TATATEXT_RENAME_ATTEMPTS", "many")
+		want := defaultPolicy()
+		want.ShutdownGrace = 10 * time.Second
+		if got := loadPolicy(); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// DEFAULT_MAX_PROCESSES caps concurrent child processes across all endpoints,
+// overridable with TATATEXT_MAX_PROCESSES. DEFAULT_MAX_EXTRACTIONS separately
+// caps concurrent yt-dlp extractions (TATATEXT_MAX_EXTRACTIONS), so a burst
+// of downloads from the browser can't thrash the machine.
+const (
+	DEFAULT_MAX_PROCESSES   = 16
+	DEFAULT_MAX_EXTRACTIONS = 3
+)
+
+var (
+	errTooManyProcesses   = errors.New("too many child processes running, try again shortly")
+	errTooManyExtractions = errors.New("too many extractions running, try again shortly")
+)
+
+// extractSlots is a semaphore held by each running extraction.
+var extractSlots = make(chan struct{}, DEFAULT_MAX_EXTRACTIONS)
+
+// processTracker counts running child processes, remembers the peak and the
+// total ever started, and refuses to start more than limit at once.
+type processTracker struct {
+	mu        sync.Mutex
+	limit     int
+	running   int
+	highWater int
+	total     int64
+}
+
+var procs = &processTracker{limit: DEFAULT_MAX_PROCESSES}
+
+func loadProcessLimit() {
+	if v := os.Getenv("TATATEXT_MAX_PROCESSES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			procs.limit = n
+		} else {
+			log.Printf("ignoring invalid TATATEXT_MAX_PROCESSES %q", v)
+		}
+	}
+	if v := os.Getenv("TATATEXT_MAX_EXTRACTIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			extractSlots = make(chan struct{}, n)
+		} else {
+			log.Printf("ignoring invalid TATATEXT_MAX_EXTRACTIONS %q", v)
+		}
+	}
+}
+
+func (p *processTracker) acquire() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running >= p.limit {
+		return errTooManyProcesses
+	}
+	p.running++
+	p.total++
+	p.highWater = max(p.highWater, p.running)
+	return nil
+}
+
+func (p *processTracker) release() {
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+}
+
+func (p *processTracker) stats() map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]any{
+		"running":   p.running,
+		"highWater": p.highWater,
+		"total":     p.total,
+		"limit":     p.limit,
+	}
+}
+
+// runProcess is cmd.Run counted against the process cap.
+func runProcess(cmd *exec.Cmd) error {
+	if err := procs.acquire(); err != nil {
+		return err
+	}
+	defer procs.release()
+	return cmd.Run()
+}
+
+// downloadProcess is cmd.Output counted against the process cap only. It is
+// for yt-dlp runs that download whole files (merged /video, /audio
+// sections), which can take minutes and must not hold an extraction slot
+// meanwhile.
+func downloadProcess(cmd *exec.Cmd) ([]byte, error) {
+	if err := procs.acquire(); err != nil {
+		return nil, err
+	}
+	defer procs.release()
+	return cmd.Output()
+}
+
+// outputProcess is cmd.Output counted against the process cap. It is used
+// for extractions (short yt-dlp runs whose output we parse), so it also
+// takes an extraction slot, failing fast rather than queueing when none is
+// free.
+func outputProcess(cmd *exec.Cmd) ([]byte, error) {
+	select {
+	case extractSlots <- struct{}{}:
+		defer func() { <-extractSlots }()
+	default:
+		return nil, errTooManyExtractions
+	}
+	if err := procs.acquire(); err != nil {
+		return nil, err
+	}
+	defer procs.release()
+	return cmd.Output()
+}
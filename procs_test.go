@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"testing"
+)
+
+// Long downloads must keep working, and must not block extractions, when
+// every extraction slot is taken.
+func TestDownloadProcessSkipsExtractionSlots(t *testing.T) {
+	for i := 0; i < cap(extractSlots); i++ {
+		extractSlots <- struct{}{}
+	}
+	t.Cleanup(func() {
+		for len(extractSlots) > 0 {
+			<-extractSlots
+		}
+	})
+
+	if _, err := outputProcess(exec.Command("true")); !errors.Is(err, errTooManyExtractions) {
+		t.Errorf("outputProcess with no free slot: err = %v, want errTooManyExtractions", err)
+	}
+	if _, err := downloadProcess(exec.Command("true")); err != nil {
+		t.Errorf("downloadProcess with no free slot: %v", err)
+	}
+}
+
+// A request turned away for capacity must say so, not look like a yt-dlp
+// failure, and must not count towards breakage detection.
+func TestCapacityErrorsAreNotExtractionFailures(t *testing.T) {
+	fakeYtDlp(t, "")
+	query := "/?url=" + url.QueryEscape("https://www.youtube.com/watch?v=test")
+	check := func(t *testing.T, name string, h http.HandlerFunc, status int, code string) {
+		t.Helper()
+		failures := len(breakage.failures)
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, query, nil))
+		var body struct{ Code string }
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != status || body.Code != code {
+			t.Errorf("%s: %d %s, want %d %s", name, rec.Code, body.Code, status, code)
+		}
+		if len(breakage.failures) != failures {
+			t.Errorf("%s: recorded as a breakage failure", name)
+		}
+	}
+
+	t.Run("no extraction slot", func(t *testing.T) {
+		for i := 0; i < cap(extractSlots); i++ {
+			extractSlots <- struct{}{}
+		}
+		t.Cleanup(func() {
+			for len(extractSlots) > 0 {
+				<-extractSlots
+			}
+		})
+		for name, h := range map[string]http.HandlerFunc{
+			"/audio":      handleAudio,
+			"/formats":    handleFormats,
+			"/live":       handleLive,
+			"/metadata":   handleMetadata,
+			"/storyboard": handleStoryboard,
+			"/subtitles":  handleSubtitles,
+		} {
+			check(t, name, h, http.StatusTooManyRequests, ErrTooManyExtractions)
+		}
+	})
+	t.Run("no process slot", func(t *testing.T) {
+		limit := procs.limit
+		procs.limit = 0
+		t.Cleanup(func() { procs.limit = limit })
+		check(t, "/video", handleVideo, http.StatusServiceUnavailable, ErrTooManyProcesses)
+	})
+}
+
+func TestYtDlpFailureKeepsCapacityErrors(t *testing.T) {
+	for _, err := range []error{errTooManyProcesses, errTooManyExtractions} {
+		var ye *ytdlpError
+		if got := ytdlpFailure(err, ""); errors.As(got, &ye) {
+			t.Errorf("ytdlpFailure(%v) = %#v, want the capacity error itself", err, got)
+		}
+	}
+	var ye *ytdlpError
+	if !errors.As(ytdlpFailure(errors.New("exit status 1"), "ERROR: Video unavailable"), &ye) {
+		t.Error("a yt-dlp failure was not classified")
+	}
+}
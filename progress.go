@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// downloadProgress is the state of one /audio proxy copy, published to
+// /progress subscribers.
+type downloadProgress struct {
+	mu      sync.Mutex
+	bytes   int64
+	total   int64 // -1 when unknown
+	status  string
+	changed chan struct{} // closed and replaced on every update
+}
+
+type progressEvent struct {
+	Bytes  int64  `json:"bytes"`
+	Total  int64  `json:"total"`
+	Status string `json:"status"`
+}
+
+var (
+	progressMu sync.Mutex
+	progress   = make(map[string]*downloadProgress)
+)
+
+// startProgress registers a download and returns its request ID.
+func startProgress(total int64) (string, *downloadProgress, error) {
+	id, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	p := &downloadProgress{total: total, status: "downloading", changed: make(chan struct{})}
+	progressMu.Lock()
+	progress[id] = p
+	progressMu.Unlock()
+	return id, p, nil
+}
+
+func lookupProgress(id string) *downloadProgress {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	return progress[id]
+}
+
+func (p *downloadProgress) update(fn func()) {
+	p.mu.Lock()
+	fn()
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+}
+
+// finish records the final status and forgets the download after a minute,
+// long enough for a late subscriber to see how it ended.
+func (p *downloadProgress) finish(id, status string) {
+	p.update(func() { p.status = status })
+	time.AfterFunc(time.Minute, func() {
+		progressMu.Lock()
+		delete(progress, id)
+		progressMu.Unlock()
+	})
+}
+
+func (p *downloadProgress) snapshot() (progressEvent, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return progressEvent{p.bytes, p.total, p.status}, p.changed
+}
+
+// progressWriter counts bytes written through it into a downloadProgress.
+type progressWriter struct {
+	w io.Writer
+	p *downloadProgress
+}
+
+func (pw progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.update(func() { pw.p.bytes += int64(n) })
+	return n, err
+}
+
+// handleProgress streams Server-Sent Events for the /audio request whose
+// X-Request-Id is given as ?id=, until the download completes or fails.
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	p := lookupProgress(r.URL.Query().Get("id"))
+	if p == nil {
+		writeJSONError(w, http.StatusNotFound, "", "unknown request id")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "", "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		ev, changed := p.snapshot()
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		if ev.Status != "downloading" {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		// At most a few events a second, however small the reads are
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const primaryOrigin = "https://tatatext.com"
+
+// originLimiter enforces a requests-per-minute limit per Origin header using
+// fixed one-minute windows. A limit of 0 means unlimited.
+type originLimiter struct {
+	mu           sync.Mutex
+	limits       map[string]int // per-origin overrides
+	defaultLimit int            // for origins without an override
+	windows      map[string]*rateWindow
+	lastPrune    time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newOriginLimiterFromEnv reads TATATEXT_ORIGIN_LIMITS ("origin=rpm,...") and
+// TATATEXT_ORIGIN_RATE_LIMIT (default rpm for other origins). The primary
+// tatatext.com origin is unlimited unless it is listed explicitly.
+func newOriginLimiterFromEnv() *originLimiter {
+	l := &originLimiter{
+		limits:  map[string]int{primaryOrigin: 0},
+		windows: make(map[string]*rateWindow),
+	}
+	if v := os.Getenv("TATATEXT_ORIGIN_RATE_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("ignoring invalid TATATEXT_ORIGIN_RATE_LIMIT %q", v)
+		} else {
+			l.defaultLimit = n
+		}
+	}
+	for _, pair := range strings.Split(os.Getenv("TATATEXT_ORIGIN_LIMITS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			log.Printf("ignoring invalid origin limit %q", pair)
+			continue
+		}
+		n, err := strconv.Atoi(pair[i+1:])
+		if err != nil || n < 0 {
+			log.Printf("ignoring invalid origin limit %q", pair)
+			continue
+		}
+		l.limits[strings.TrimRight(pair[:i], "/")] = n
+	}
+	return l
+}
+
+// allow records a request from origin and reports whether it is within the
+// limit. If not, it also returns how long until the window resets.
+func (l *originLimiter) allow(origin string, now time.Time) (bool, time.Duration) {
+	limit, ok := l.limits[origin]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if limit == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Drop ended windows now and then, so a page sending arbitrary Origin
+	// values can't grow the map without bound
+	if now.Sub(l.lastPrune) >= time.Minute {
+		for o, win := range l.windows {
+			if now.Sub(win.start) >= time.Minute {
+				delete(l.windows, o)
+			}
+		}
+		l.lastPrune = now
+	}
+	win := l.windows[origin]
+	if win == nil || now.Sub(win.start) >= time.Minute {
+		win = &rateWindow{start: now}
+		l.windows[origin] = win
+	}
+	if win.count >= limit {
+		return false, win.start.Add(time.Minute).Sub(now)
+	}
+	win.count++
+	return true, 0
+}
+
+// middleware rejects requests over their origin's limit with 429 and a
+// Retry-After header. Requests without an Origin (local tools) and CORS
+// preflights are not counted.
+func (l *originLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retry := l.allow(origin, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "", "rate limit exceeded for origin "+origin)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
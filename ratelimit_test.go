@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOriginLimiterEvictsExpiredWindows(t *testing.T) {
+	l := &originLimiter{limits: map[string]int{}, defaultLimit: 10, windows: make(map[string]*rateWindow)}
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		l.allow(fmt.Sprintf("https://site%d.example", i), now)
+	}
+	if len(l.windows) != 100 {
+		t.Fatalf("%d windows, want 100", len(l.windows))
+	}
+
+	later := now.Add(time.Minute)
+	if ok, _ := l.allow("https://other.example", later); !ok {
+		t.Fatal("request in a fresh window refused")
+	}
+	if _, ok := l.windows["https://site0.example"]; ok {
+		t.Error("expired origin still tracked")
+	}
+	if len(l.windows) != 1 {
+		t.Errorf("%d windows after the others expired, want 1", len(l.windows))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// router wraps http.ServeMux and remembers the registered paths, so that
+// "/Audio" or "/ping/" reach the right handler and unknown paths get a JSON
+// 404 listing what is available.
+type router struct {
+	mux   *http.ServeMux
+	paths map[string]bool
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux(), paths: make(map[string]bool)}
+}
+
+func (rt *router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	rt.mux.HandleFunc(pattern, handler)
+	rt.paths[pattern] = true
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := canonicalPath(r.URL.Path)
+	if !rt.paths[path] {
+		endpoints := make([]string, 0, len(rt.paths))
+		for p := range rt.paths {
+			endpoints = append(endpoints, p)
+		}
+		sort.Strings(endpoints)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":     "unknown endpoint " + r.URL.Path,
+			"endpoints": endpoints,
+		})
+		return
+	}
+	r.URL.Path = path
+	r.URL.RawPath = ""
+	rt.mux.ServeHTTP(w, r)
+}
+
+// canonicalPath lowercases a request path and drops any trailing slash.
+func canonicalPath(p string) string {
+	p = strings.ToLower(p)
+	if len(p) > 1 {
+		p = strings.TrimRight(p, "/")
+		if p == "" {
+			p = "/"
+		}
+	}
+	return p
+}
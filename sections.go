@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// timestampPattern matches seconds ("95", "95.5") or MM:SS / HH:MM:SS
+// ("1:35", "00:01:35.5").
+var timestampPattern = regexp.MustCompile(`^(\d+:){0,2}\d+(\.\d+)?$`)
+
+// parseTimestamp reads a start= or end= value in seconds.
+func parseTimestamp(s string) (float64, error) {
+	if !timestampPattern.MatchString(s) {
+		return 0, fmt.Errorf("invalid time %q: use seconds or HH:MM:SS", s)
+	}
+	parts := strings.Split(s, ":")
+	total := 0.0
+	for i, p := range parts {
+		v, _ := strconv.ParseFloat(p, 64)
+		if i > 0 && v >= 60 {
+			return 0, fmt.Errorf("invalid time %q: minutes and seconds must be below 60", s)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
+
+// parseSection turns start= and end= into a yt-dlp --download-sections
+// value such as "*180-420", or "" when neither is given. A missing start
+// means the beginning, a missing end the end of the video.
+func parseSection(start, end string) (string, error) {
+	if start == "" && end == "" {
+		return "", nil
+	}
+	from, to := 0.0, -1.0
+	var err error
+	if start != "" {
+		if from, err = parseTimestamp(start); err != nil {
+			return "", fmt.Errorf("start: %w", err)
+		}
+	}
+	if end != "" {
+		if to, err = parseTimestamp(end); err != nil {
+			return "", fmt.Errorf("end: %w", err)
+		}
+		if to <= from {
+			return "", errors.New("end must be after start")
+		}
+	}
+	toArg := "inf"
+	if to >= 0 {
+		toArg = strconv.FormatFloat(to, 'f', -1, 64)
+	}
+	return "*" + strconv.FormatFloat(from, 'f', -1, 64) + "-" + toArg, nil
+}
+
+// serveAudioSection has yt-dlp download just one section of the audio,
+// cut with ffmpeg, and serves it like a merged /video.
+func serveAudioSection(w http.ResponseWriter, r *http.Request, source, selector, section, convertTo string) {
+	args := []string{
+		"-f", selector,
+		"--download-sections", section,
+	}
+	if convertTo != "" {
+		args = append(args, "-x", "--audio-format", convertTo)
+	}
+	downloadToTempAndServe(w, r, source, args, func(fileExt string) (string, bool) {
+		ext := outputExtension(convertTo, fileExt, "")
+		if !extAllowed(ext) {
+			writeExtNotAllowed(w, ext)
+			return "", false
+		}
+		return ext, true
+	})
+}
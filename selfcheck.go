@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// selfCheckYtDlp re-runs `yt-dlp --version` every SelfCheckInterval (an hour
+// by default). If the active binary has been deleted or corrupted, it
+// restores the embedded copy, or failing that tries an update, so the helper
+// recovers before the next download.
+func selfCheckYtDlp(ctx context.Context) {
+	ticker := time.NewTicker(policy.SelfCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			validateYtDlp()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validateYtDlp checks the active binary and repairs it if it doesn't run.
+func validateYtDlp() {
+	path := ytdlp.Load().path
+
+	if v := getYtDlpVersion(path); v != "unknown" {
+		if systemYtDlp {
+			// Picks up package manager upgrades and recovery
+			ytdlp.Store(&ytdlpBinary{path: path, version: v})
+			updateMu.Lock()
+			ready = true
+			updateMu.Unlock()
+		}
+		return
+	}
+
+	if systemYtDlp {
+		log.Printf("self-check: system yt-dlp at %s no longer runs", path)
+		updateMu.Lock()
+		wasReady := ready
+		ready = false
+		updateMu.Unlock()
+		if wasReady {
+			showNotification("tatatext Helper", "yt-dlp at "+path+" stopped working. Reinstall it with your package manager.")
+		}
+		return
+	}
+
+	log.Printf("self-check: yt-dlp at %s no longer runs, repairing", path)
+	// Mark it unknown so the update fallback below doesn't consider it current
+	ytdlp.Store(&ytdlpBinary{path: path, version: "unknown"})
+	updateMu.Lock()
+	ready = false
+	updateMu.Unlock()
+
+	if b, err := restoreEmbeddedYtDlp(); err != nil {
+		log.Printf("self-check: restoring embedded yt-dlp failed: %v", err)
+	} else {
+		ytdlp.Store(b)
+		updateMu.Lock()
+		ready = true
+		updateMu.Unlock()
+		log.Printf("self-check: restored embedded yt-dlp %s", b.version)
+		showNotification("tatatext Helper", "yt-dlp stopped working and was restored.")
+		return
+	}
+
+	log.Println("self-check: embedded yt-dlp did not run, trying an update")
+	if err := checkAndUpdate(true); err == nil {
+		updateMu.Lock()
+		ok := ready
+		updateMu.Unlock()
+		if ok {
+			showNotification("tatatext Helper", "yt-dlp stopped working and was reinstalled.")
+			return
+		}
+	}
+	showNotification("tatatext Helper", "yt-dlp stopped working and could not be repaired. Please restart the helper.")
+}
+
+// restoreEmbeddedYtDlp overwrites the installed binary with the copy embedded
+// at build time, which is always a known-good baseline for this platform,
+// and checks that it runs.
+func restoreEmbeddedYtDlp() (*ytdlpBinary, error) {
+	outPath := ytdlpInstallPath()
+	if err := writeEmbeddedYtDlp(outPath); err != nil {
+		return nil, err
+	}
+	v := getYtDlpVersion(outPath)
+	if v == "unknown" {
+		return nil, errors.New("embedded yt-dlp does not run")
+	}
+	return &ytdlpBinary{path: outPath, version: v}, nil
+}
+
+// checkLoopback requests our own /ping once the listener is bound. Listening
+// can succeed while a firewall still drops connections to 127.0.0.1, which
+// the web app only sees as "helper not detected", so point the user at the
+// firewall when that happens.
+func checkLoopback(addr string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		var resp *http.Response
+		resp, err = client.Get("http://" + addr + "/ping")
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+			continue
+		}
+		log.Printf("loopback check: helper reachable at http://%s", addr)
+		return
+	}
+	log.Printf("loopback check: helper not reachable at http://%s: %v", addr, err)
+	showNotification("tatatext Helper",
+		"The helper is running but can't be reached at "+addr+". Allow tatatext-helper through your firewall or security software for local connections.")
+}
+
+// extractionVerified is set once the extract readiness probe has succeeded.
+// It only matters with TATATEXT_READINESS_PROBE=extract.
+var (
+	readinessProbe     string
+	extractionVerified atomic.Bool
+)
+
+// probeExtraction resolves a known-good video with --simulate until it
+// succeeds, backing off from 5s to 5 minutes between attempts. This catches
+// setups where yt-dlp runs but can't reach YouTube (egress rules, proxies).
+func probeExtraction(ctx context.Context, video string) {
+	backoff := 5 * time.Second
+	for attempt := 1; ; attempt++ {
+		cmd := ytdlpCommandContext(ctx, "--simulate", "--no-playlist", "--quiet", "--", video)
+		err := runProcess(cmd)
+		if err == nil {
+			extractionVerified.Store(true)
+			log.Printf("readiness probe: extraction works (attempt %d)", attempt)
+			return
+		}
+		log.Printf("readiness probe: extraction failed (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, 5*time.Minute)
+	}
+}
+
+// healthy reports whether the helper should receive traffic: yt-dlp runs and,
+// in extract probe mode, has successfully extracted a video.
+func healthy() bool {
+	updateMu.Lock()
+	isReady := ready
+	updateMu.Unlock()
+	return isReady && (readinessProbe != "extract" || extractionVerified.Load())
+}
+
+// handleHealth answers 200 when healthy and 503 otherwise, for load balancers
+// and service managers.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "starting"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
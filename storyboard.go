@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// ytdlpStoryboardFormat is the subset of a yt-dlp format entry describing a
+// storyboard (format_note "storyboard", ids sb0, sb1, ...). Each fragment is
+// one sprite sheet of rows x columns tiles.
+type ytdlpStoryboardFormat struct {
+	FormatID   string  `json:"format_id"`
+	FormatNote string  `json:"format_note"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Rows       int     `json:"rows"`
+	Columns    int     `json:"columns"`
+	FPS        float64 `json:"fps"`
+	Fragments  []struct {
+		URL      string  `json:"url"`
+		Duration float64 `json:"duration"`
+	} `json:"fragments"`
+}
+
+type storyboardImage struct {
+	URL      string  `json:"url"`
+	Duration float64 `json:"duration"`
+}
+
+// handleStoryboard returns the highest-resolution storyboard of a video: the
+// sprite sheet URLs plus the tile geometry needed to map a timestamp to a
+// tile. Videos without storyboards get a 404.
+func handleStoryboard(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := ytdlpCommandContext(r.Context(), "-J", "--no-playlist", "--", youtubeURL)
+	cmd.Stderr = &stderr
+	out, err := outputProcess(cmd)
+	if err != nil {
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+		return
+	}
+	var info struct {
+		Formats []ytdlpStoryboardFormat `json:"formats"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to parse yt-dlp output: "+err.Error())
+		return
+	}
+
+	var best *ytdlpStoryboardFormat
+	for i := range info.Formats {
+		f := &info.Formats[i]
+		if f.FormatNote != "storyboard" || len(f.Fragments) == 0 || f.Rows == 0 || f.Columns == 0 {
+			continue
+		}
+		if best == nil || f.Width*f.Height > best.Width*best.Height {
+			best = f
+		}
+	}
+	if best == nil {
+		writeJSONError(w, http.StatusNotFound, "", "no storyboard available for this video")
+		return
+	}
+
+	interval := 0.0
+	if best.FPS > 0 {
+		interval = 1 / best.FPS
+	}
+	images := make([]storyboardImage, len(best.Fragments))
+	for i, frag := range best.Fragments {
+		images[i] = storyboardImage{URL: frag.URL, Duration: frag.Duration}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Columns    int               `json:"columns"`
+		Rows       int               `json:"rows"`
+		TileWidth  int               `json:"tileWidth"`
+		TileHeight int               `json:"tileHeight"`
+		Interval   float64           `json:"interval"`
+		Images     []storyboardImage `json:"images"`
+	}{best.Columns, best.Rows, best.Width, best.Height, interval, images})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// subtitleLangPattern limits lang= to plain language tags. yt-dlp treats
+// --sub-langs as regular expressions, so anything else is rejected.
+var subtitleLangPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// subtitleContentTypes are the formats /subtitles can return. YouTube serves
+// VTT directly; SRT is converted by yt-dlp and needs ffmpeg.
+var subtitleContentTypes = map[string]string{
+	"vtt": "text/vtt; charset=utf-8",
+	"srt": "application/x-subrip; charset=utf-8",
+}
+
+// handleSubtitles returns a video's captions for one language (lang=,
+// default en) as VTT or SRT (format=). Uploaded captions are preferred over
+// auto-generated ones. A language without captions gets a 404.
+func handleSubtitles(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = "en"
+	}
+	if !subtitleLangPattern.MatchString(lang) {
+		writeJSONError(w, http.StatusBadRequest, "", "invalid lang parameter")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "vtt"
+	}
+	contentType, ok := subtitleContentTypes[format]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "", "format must be vtt or srt")
+		return
+	}
+	if format == "srt" && !ffmpegAvailable() {
+		writeJSONError(w, http.StatusUnprocessableEntity, ErrFFmpegMissing, FFMPEG_MISSING_HINT)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "tatatext-subs-")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to create temp dir: "+err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{
+		"--skip-download",
+		"--no-playlist",
+		"--write-subs",
+		"--write-auto-subs",
+		"--sub-langs", lang,
+		"--sub-format", "vtt",
+		"-o", filepath.Join(dir, "subs.%(ext)s"),
+	}
+	if format == "srt" {
+		args = append(args, "--convert-subs", "srt")
+	}
+	var stderr bytes.Buffer
+	cmd := ytdlpCommandContext(r.Context(), append(args, "--", youtubeURL)...)
+	cmd.Stderr = &stderr
+	if _, err := outputProcess(cmd); err != nil {
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+		return
+	}
+
+	// yt-dlp names the file subs.<lang>.<format>
+	matches, _ := filepath.Glob(filepath.Join(dir, "subs.*."+format))
+	if len(matches) == 0 {
+		writeJSONError(w, http.StatusNotFound, ErrNoSubtitles, "no "+lang+" captions available for this video")
+		return
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to read captions: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Language", lang)
+	w.Write(data)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// downloadToTempAndServe has yt-dlp download source into a temp dir using
+// args (format and postprocessing options), then serves the file with Range
+// support and removes it. pickExt maps the file's extension to the one the
+// client sees; returning false means it has already written an error.
+func downloadToTempAndServe(w http.ResponseWriter, r *http.Request, source string, args []string, pickExt func(fileExt string) (string, bool)) {
+	dir, err := os.MkdirTemp("", "tatatext-download-")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to create temp dir: "+err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	args = append([]string{
+		"--no-playlist",
+		"--playlist-items", "1",
+		"--no-progress",
+		"-o", filepath.Join(dir, "download.%(ext)s"),
+		"--print", "after_move:%(title)s",
+		"--print", "after_move:filepath",
+	}, args...)
+	var stderr bytes.Buffer
+	cmd := ytdlpCommandContext(r.Context(), append(args, "--", source)...)
+	cmd.Stderr = &stderr
+	out, err := downloadProcess(cmd)
+	if r.Context().Err() != nil {
+		log.Printf("download cancelled by client: %s", source)
+		return
+	}
+	if err != nil {
+		writeYtDlpError(w, ytdlpFailure(err, stderr.String()))
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 2 {
+		writeJSONError(w, http.StatusInternalServerError, "", "yt-dlp did not report the downloaded file")
+		return
+	}
+	title, path := lines[0], lines[len(lines)-1]
+
+	f, err := os.Open(path)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "failed to open downloaded file: "+err.Error())
+		return
+	}
+	defer f.Close()
+
+	ext, ok := pickExt(strings.TrimPrefix(filepath.Ext(path), "."))
+	if !ok {
+		return
+	}
+	setDownloadHeaders(w.Header(), title, ext)
+	http.ServeContent(w, r, filepath.Base(path), time.Time{}, f)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "sync/atomic"
+
+// usageCounters tracks /audio traffic since startup. The fields are atomics
+// so the download path never contends on a lock.
+type usageCounters struct {
+	requests     atomic.Int64
+	completed    atomic.Int64
+	failed       atomic.Int64
+	cancelled    atomic.Int64
+	bytesProxied atomic.Int64
+}
+
+var usage usageCounters
+
+// recordDownload counts one finished download by status.
+func (u *usageCounters) recordDownload(bytes int64, status string) {
+	u.bytesProxied.Add(bytes)
+	switch status {
+	case "completed":
+		u.completed.Add(1)
+	case "failed":
+		u.failed.Add(1)
+	case "cancelled":
+		u.cancelled.Add(1)
+	}
+}
+
+// snapshot reads each counter once. The values are not taken together, so a
+// download finishing mid-read may show up in one counter and not another;
+// that is fine for display.
+func (u *usageCounters) snapshot() map[string]any {
+	return map[string]any{
+		"requests":     u.requests.Load(),
+		"completed":    u.completed.Load(),
+		"failed":       u.failed.Load(),
+		"cancelled":    u.cancelled.Load(),
+		"bytesProxied": u.bytesProxied.Load(),
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// Run with -race. Counters only grow, so every snapshot must be at least
+// the previous one, and the final one must be exact.
+func TestUsageCountersConcurrent(t *testing.T) {
+	var u usageCounters
+	const workers, perWorker = 16, 1000
+	statuses := []string{"completed", "failed", "cancelled"}
+
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		prev := u.snapshot()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			snap := u.snapshot()
+			for k, v := range snap {
+				if v.(int64) < prev[k].(int64) {
+					t.Errorf("%s went backwards: %d -> %d", k, prev[k], v)
+					return
+				}
+			}
+			prev = snap
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				u.requests.Add(1)
+				u.recordDownload(10, statuses[(w+i)%len(statuses)])
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	<-readerDone
+
+	snap := u.snapshot()
+	total := int64(workers * perWorker)
+	if snap["requests"] != total || snap["bytesProxied"] != total*10 {
+		t.Errorf("requests = %v, bytesProxied = %v; want %d and %d", snap["requests"], snap["bytesProxied"], total, total*10)
+	}
+	if sum := snap["completed"].(int64) + snap["failed"].(int64) + snap["cancelled"].(int64); sum != total {
+		t.Errorf("outcomes add up to %d, want %d", sum, total)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Format selectors for /video. Merging separate video and audio streams
+// needs ffmpeg; without it only progressive (single-file) formats work.
+const (
+	VIDEO_FORMAT_MERGED      = "bestvideo[ext=mp4]+bestaudio[ext=m4a]/best[ext=mp4]/best"
+	VIDEO_FORMAT_PROGRESSIVE = "best[ext=mp4]"
+)
+
+// handleVideo downloads a full video as mp4. With ffmpeg, yt-dlp fetches and
+// merges the best streams into a temp file that is then served; without it,
+// the best progressive mp4 is proxied like /audio.
+func handleVideo(w http.ResponseWriter, r *http.Request) {
+	youtubeURL := videoURLParam(r)
+	if youtubeURL == "" {
+		writeJSONError(w, http.StatusBadRequest, "", "url parameter required")
+		return
+	}
+
+	if ffmpegAvailable() {
+		serveMergedVideo(w, r, youtubeURL)
+	} else {
+		proxyProgressiveVideo(w, r, youtubeURL)
+	}
+}
+
+// serveMergedVideo has yt-dlp download and merge into a temp dir, then
+// serves the result with Range support and removes it.
+func serveMergedVideo(w http.ResponseWriter, r *http.Request, youtubeURL string) {
+	args := []string{
+		"-f", VIDEO_FORMAT_MERGED,
+		"--merge-output-format", "mp4",
+	}
+	// yt-dlp names the merged file after its real container
+	downloadToTempAndServe(w, r, youtubeURL, args, func(fileExt string) (string, bool) {
+		return outputExtension("", fileExt, "video/mp4"), true
+	})
+}
+
+// proxyProgressiveVideo resolves a single-file mp4 and streams it through.
+func proxyProgressiveVideo(w http.ResponseWriter, r *http.Request, youtubeURL string) {
+	video, err := resolveFormat(r.Context(), youtubeURL, VIDEO_FORMAT_PROGRESSIVE)
+	var ye *ytdlpError
+	if errors.As(err, &ye) && ye.Code == ErrFormatUnavailable {
+		// Only a merge could produce this video, and that needs ffmpeg
+		writeJSONError(w, http.StatusUnprocessableEntity, ErrFFmpegMissing, FFMPEG_MISSING_HINT)
+		return
+	} else if err != nil {
+		writeYtDlpError(w, err)
+		return
+	}
+
+	resp, body, err := fetchAudio(r.Context(), video.URL, r.Header.Get("Range"))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "", "download failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		w.Header().Set("Content-Range", resp.Header.Get("Content-Range"))
+		writeJSONError(w, http.StatusRequestedRangeNotSatisfiable, "", "requested range not satisfiable")
+		return
+	}
+
+	copyUpstreamHeaders(w.Header(), resp.Header)
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "video/mp4")
+	}
+	setDownloadHeaders(w.Header(), video.Title, outputExtension("", video.Ext, w.Header().Get("Content-Type")))
+	if resp.StatusCode == http.StatusPartialContent {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	n, err := io.Copy(w, body)
+	if err != nil || (resp.ContentLength >= 0 && n < resp.ContentLength) {
+		log.Printf("video proxy interrupted after %d bytes: %v", n, err)
+		panic(http.ErrAbortHandler)
+	}
+}